@@ -3,6 +3,7 @@ package main
 import (
 	"context"
 	"flag"
+	"fmt"
 	"log"
 	"os"
 	"os/signal"
@@ -75,7 +76,12 @@ func registerServices(server *runtime.Server, cfg *config.Config, logger *zap.Lo
 			return nil // Continue with other services
 		}
 
-		storageService := storage.NewStorageService(containerMgr, containerConfig, logger)
+		storageConfig, err := storage.ParseConfig(serviceConfig.Config)
+		if err != nil {
+			return fmt.Errorf("invalid storage service config: %w", err)
+		}
+
+		storageService := storage.NewStorageService(containerMgr, containerConfig, storageConfig, logger)
 		server.RegisterService(storageService)
 		logger.Info("Storage service registered")
 	}
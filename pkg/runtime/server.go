@@ -41,6 +41,10 @@ func (s *Server) RegisterService(service Service) {
 		// s.router.Any(route+"/*path", gin.WrapH(service.Handler()))
 
 	}
+
+	if fallback, ok := service.(FallbackService); ok {
+		s.router.NoRoute(gin.WrapH(fallback.FallbackHandler()))
+	}
 }
 
 func (s *Server) GetContainerManager() *containers.ContainerManager {
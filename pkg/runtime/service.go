@@ -28,6 +28,12 @@ type Service interface {
 	Routes() []string
 }
 
+// Implemented by services that also take the requests no registered route
+// matched, such as the storage XML API living at the root of the host
+type FallbackService interface {
+	FallbackHandler() http.Handler
+}
+
 // Manages all registered services
 type ServiceRegistry struct {
 	services map[string]Service
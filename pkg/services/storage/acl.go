@@ -0,0 +1,410 @@
+package storage
+
+import (
+	"net/http"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+var (
+	projectOwners  = aclEntry{Entity: "project-owners-" + projectNumber, Role: "OWNER"}
+	projectEditors = aclEntry{Entity: "project-editors-" + projectNumber, Role: "OWNER"}
+	projectViewers = aclEntry{Entity: "project-viewers-" + projectNumber, Role: "READER"}
+)
+
+// ACLs named by `predefinedAcl` on buckets. Buckets are owned by the project owners.
+var predefinedBucketACLs = map[string][]aclEntry{
+	"private":           {projectOwners},
+	"projectPrivate":    {projectOwners, projectEditors, projectViewers},
+	"publicRead":        {projectOwners, projectEditors, projectViewers, {Entity: "allUsers", Role: "READER"}},
+	"publicReadWrite":   {projectOwners, projectEditors, projectViewers, {Entity: "allUsers", Role: "WRITER"}},
+	"authenticatedRead": {projectOwners, projectEditors, projectViewers, {Entity: "allAuthenticatedUsers", Role: "READER"}},
+}
+
+// ACLs named by `predefinedAcl` and `predefinedDefaultObjectAcl` on objects.
+// Uploaders aren't known, so objects are owned by the bucket owners.
+var predefinedObjectACLs = map[string][]aclEntry{
+	"private":                {projectOwners},
+	"projectPrivate":         {projectOwners, projectEditors, projectViewers},
+	"publicRead":             {projectOwners, {Entity: "allUsers", Role: "READER"}},
+	"authenticatedRead":      {projectOwners, {Entity: "allAuthenticatedUsers", Role: "READER"}},
+	"bucketOwnerRead":        {projectOwners},
+	"bucketOwnerFullControl": {projectOwners},
+}
+
+func errUniformBucketLevelAccess(action string, kind aclKind) *apiError {
+	subject := "an object"
+	if kind == bucketACL {
+		subject = "a bucket"
+	}
+
+	return errBadRequest("Cannot %s legacy ACL for %s when uniform bucket-level access is enabled. "+
+		"Read more at https://cloud.google.com/storage/docs/uniform-bucket-level-access", action, subject)
+}
+
+// Looks up a predefined ACL by the name a request parameter gave
+func parsePredefinedACL(param, name string, kind aclKind) ([]aclEntry, error) {
+	acls := predefinedObjectACLs
+	if kind == bucketACL {
+		acls = predefinedBucketACLs
+	}
+
+	entries, ok := acls[name]
+	if !ok {
+		return nil, errBadRequest("Invalid value for %s: %s", param, name)
+	}
+
+	return slices.Clone(entries), nil
+}
+
+// Reads the predefined ACL an object write asks for, so that a bad value
+// fails the request before anything is written. Returns nil when none is given.
+func (s *StorageService) parsePredefinedObjectACL(bucket, param, name string) ([]aclEntry, error) {
+	if name == "" {
+		return nil, nil
+	}
+
+	entries, err := parsePredefinedACL(param, name, objectACL)
+	if err != nil {
+		return nil, err
+	}
+
+	if s.buckets.Get(bucket, s.defaultBucketAttrs(time.Time{})).UniformBucketLevelAccess {
+		return nil, errUniformBucketLevelAccess("insert", objectACL)
+	}
+
+	return entries, nil
+}
+
+func objectACLKey(bucket string, info minio.ObjectInfo) aclKey {
+	return aclKey{
+		Kind:       objectACL,
+		Bucket:     bucket,
+		Object:     info.Key,
+		Generation: parseObjectAttrs(info).Generation,
+	}
+}
+
+// Gives the generation just written the ACL its write asked for
+func (s *StorageService) storeObjectACL(bucket string, info minio.ObjectInfo, entries []aclEntry) {
+	if entries == nil {
+		return
+	}
+
+	s.acls.Put(objectACLKey(bucket, info), entries)
+}
+
+// Gives a new generation the ACL its write asked for, or else a copy of the bucket's
+// default object ACL, which later changes to the default don't affect. In unversioned
+// buckets the generation replaced the object's previous one, whose ACL goes with it.
+func (s *StorageService) initObjectACL(bucket string, info minio.ObjectInfo, entries []aclEntry) {
+	if entries == nil {
+		entries = s.acls.Get(aclKey{Kind: defaultObjectACL, Bucket: bucket})
+	}
+
+	key := objectACLKey(bucket, info)
+	if !s.buckets.Get(bucket, s.defaultBucketAttrs(time.Time{})).Versioning {
+		s.acls.DeleteObject(bucket, info.Key, key.Generation)
+	}
+
+	s.acls.Put(key, entries)
+}
+
+// Drops the ACL of a generation that was deleted. Versioned buckets keep the live
+// generation as a noncurrent version unless it was removed for good.
+func (s *StorageService) removeObjectACL(bucket string, info minio.ObjectInfo, permanent bool) {
+	if !permanent && s.buckets.Get(bucket, s.defaultBucketAttrs(time.Time{})).Versioning {
+		return
+	}
+
+	s.acls.Delete(objectACLKey(bucket, info))
+}
+
+// The XML API names predefined ACLs in the `x-goog-acl` header as `public-read` rather than `publicRead`
+func xmlCannedACL(value string) string {
+	words := strings.Split(value, "-")
+	for i := 1; i < len(words); i++ {
+		if words[i] != "" {
+			words[i] = strings.ToUpper(words[i][:1]) + words[i][1:]
+		}
+	}
+
+	return strings.Join(words, "")
+}
+
+func validateACLEntry(kind aclKind, entry aclEntry) error {
+	roles := []string{"OWNER", "READER"}
+	if kind == bucketACL {
+		roles = append(roles, "WRITER")
+	}
+
+	if !slices.Contains(roles, entry.Role) {
+		return errBadRequest("Invalid role: %s", entry.Role)
+	}
+
+	if entry.Entity == "allUsers" || entry.Entity == "allAuthenticatedUsers" {
+		return nil
+	}
+
+	for _, prefix := range []string{"user-", "group-", "domain-", "project-owners-", "project-editors-", "project-viewers-"} {
+		if len(entry.Entity) > len(prefix) && strings.HasPrefix(entry.Entity, prefix) {
+			return nil
+		}
+	}
+
+	return errBadRequest("Invalid entity: %s", entry.Entity)
+}
+
+// The ACL a request addresses, along with the etag of the resource it belongs to
+type aclTarget struct {
+	key  aclKey
+	etag string
+}
+
+// Resolves the ACL a request addresses, rejecting buckets with uniform bucket-level access
+func (s *StorageService) resolveACL(r *http.Request, kind aclKind) (aclTarget, error) {
+	info, err := s.lookupBucket(r.Context(), r.PathValue("bucket"))
+	if err != nil {
+		return aclTarget{}, err
+	}
+
+	attrs := s.buckets.Get(info.Name, s.defaultBucketAttrs(info.CreationDate))
+	if attrs.UniformBucketLevelAccess {
+		action := map[string]string{
+			http.MethodGet:    "get",
+			http.MethodPost:   "insert",
+			http.MethodDelete: "delete",
+		}[r.Method]
+		if action == "" {
+			action = "update"
+		}
+
+		return aclTarget{}, errUniformBucketLevelAccess(action, kind)
+	}
+
+	target := aclTarget{
+		key:  aclKey{Kind: kind, Bucket: info.Name},
+		etag: metagenerationEtag(attrs.Metageneration),
+	}
+
+	if kind == objectACL {
+		object, err := s.statGeneration(r.Context(), info.Name, r.PathValue("object"), r.URL.Query().Get("generation"))
+		if err != nil {
+			return aclTarget{}, err
+		}
+
+		objAttrs := parseObjectAttrs(object)
+		target.key.Object = object.Key
+		target.key.Generation = objAttrs.Generation
+		target.etag = metagenerationEtag(objAttrs.Metageneration)
+	}
+
+	return target, nil
+}
+
+func renderAccessControl(r *http.Request, target aclTarget, entry aclEntry) *AccessControl {
+	key := target.key
+
+	acl := &AccessControl{
+		Kind:   "storage#objectAccessControl",
+		ID:     key.Bucket + "/" + entry.Entity,
+		Bucket: key.Bucket,
+		Entity: entry.Entity,
+		Role:   entry.Role,
+		Etag:   target.etag,
+	}
+
+	switch key.Kind {
+	case bucketACL:
+		acl.Kind = "storage#bucketAccessControl"
+		acl.SelfLink = bucketSelfLink(r, key.Bucket) + "/acl/" + url.PathEscape(entry.Entity)
+	case defaultObjectACL:
+		acl.SelfLink = bucketSelfLink(r, key.Bucket) + "/defaultObjectAcl/" + url.PathEscape(entry.Entity)
+	case objectACL:
+		acl.ID = key.Bucket + "/" + key.Object + "/" + strconv.FormatInt(key.Generation, 10) + "/" + entry.Entity
+		acl.SelfLink = objectSelfLink(r, key.Bucket, key.Object) + "/acl/" + url.PathEscape(entry.Entity)
+		acl.Object = key.Object
+		acl.Generation = key.Generation
+	}
+
+	switch {
+	case strings.HasPrefix(entry.Entity, "user-"), strings.HasPrefix(entry.Entity, "group-"):
+		_, email, _ := strings.Cut(entry.Entity, "-")
+		if strings.Contains(email, "@") {
+			acl.Email = email
+		}
+	case strings.HasPrefix(entry.Entity, "domain-"):
+		acl.Domain = strings.TrimPrefix(entry.Entity, "domain-")
+	case strings.HasPrefix(entry.Entity, "project-"):
+		team, number, _ := strings.Cut(strings.TrimPrefix(entry.Entity, "project-"), "-")
+		acl.ProjectTeam = &ProjectTeam{ProjectNumber: number, Team: team}
+	}
+
+	return acl
+}
+
+func renderAccessControls(r *http.Request, target aclTarget, entries []aclEntry) []*AccessControl {
+	items := make([]*AccessControl, 0, len(entries))
+	for _, entry := range entries {
+		items = append(items, renderAccessControl(r, target, entry))
+	}
+
+	return items
+}
+
+// Registers the list, insert, get, update, patch and delete methods of an ACL collection
+func (s *StorageService) handleACLRoutes(mux *http.ServeMux, path string, kind aclKind, readPermission, writePermission string) {
+	mux.HandleFunc("GET "+path, s.authorize(readPermission, s.handleListACL(kind)))
+	mux.HandleFunc("POST "+path, s.authorize(writePermission, s.handleInsertACL(kind)))
+	mux.HandleFunc("GET "+path+"/{entity}", s.authorize(readPermission, s.handleGetACL(kind)))
+	mux.HandleFunc("PUT "+path+"/{entity}", s.authorize(writePermission, s.handleUpdateACL(kind)))
+	mux.HandleFunc("PATCH "+path+"/{entity}", s.authorize(writePermission, s.handleUpdateACL(kind)))
+	mux.HandleFunc("DELETE "+path+"/{entity}", s.authorize(writePermission, s.handleDeleteACL(kind)))
+}
+
+func (s *StorageService) handleListACL(kind aclKind) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		target, err := s.resolveACL(r, kind)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		listKind := "storage#objectAccessControls"
+		if kind == bucketACL {
+			listKind = "storage#bucketAccessControls"
+		}
+
+		writeJSON(w, http.StatusOK, &AccessControls{
+			Kind:  listKind,
+			Items: renderAccessControls(r, target, s.acls.Get(target.key)),
+		})
+	}
+}
+
+func (s *StorageService) handleGetACL(kind aclKind) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		target, err := s.resolveACL(r, kind)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		entity := r.PathValue("entity")
+		entries := s.acls.Get(target.key)
+
+		i := slices.IndexFunc(entries, func(entry aclEntry) bool { return entry.Entity == entity })
+		if i < 0 {
+			writeError(w, errNotFound("No ACL entry for entity %s", entity))
+			return
+		}
+
+		writeJSON(w, http.StatusOK, renderAccessControl(r, target, entries[i]))
+	}
+}
+
+// Adds an entry, replacing the role of an entity that already has one
+func (s *StorageService) handleInsertACL(kind aclKind) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		target, err := s.resolveACL(r, kind)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		var req AccessControl
+		if err := readJSON(r, &req); err != nil {
+			writeError(w, err)
+			return
+		}
+
+		entry := aclEntry{Entity: req.Entity, Role: req.Role}
+		if err := validateACLEntry(kind, entry); err != nil {
+			writeError(w, err)
+			return
+		}
+
+		_, err = s.acls.Update(target.key, func(entries []aclEntry) ([]aclEntry, error) {
+			entries = slices.DeleteFunc(entries, func(e aclEntry) bool { return e.Entity == entry.Entity })
+			return append(entries, entry), nil
+		})
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, renderAccessControl(r, target, entry))
+	}
+}
+
+// Changes the role of an existing entry, for both PUT and PATCH
+func (s *StorageService) handleUpdateACL(kind aclKind) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		target, err := s.resolveACL(r, kind)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		var req AccessControl
+		if err := readJSON(r, &req); err != nil {
+			writeError(w, err)
+			return
+		}
+
+		entry := aclEntry{Entity: r.PathValue("entity"), Role: req.Role}
+		if err := validateACLEntry(kind, entry); err != nil {
+			writeError(w, err)
+			return
+		}
+
+		// Like GCS, updating an entity the ACL lacks adds it
+		_, err = s.acls.Update(target.key, func(entries []aclEntry) ([]aclEntry, error) {
+			i := slices.IndexFunc(entries, func(e aclEntry) bool { return e.Entity == entry.Entity })
+			if i < 0 {
+				return append(entries, entry), nil
+			}
+
+			entries[i] = entry
+			return entries, nil
+		})
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, renderAccessControl(r, target, entry))
+	}
+}
+
+func (s *StorageService) handleDeleteACL(kind aclKind) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		target, err := s.resolveACL(r, kind)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		entity := r.PathValue("entity")
+
+		_, err = s.acls.Update(target.key, func(entries []aclEntry) ([]aclEntry, error) {
+			i := slices.IndexFunc(entries, func(e aclEntry) bool { return e.Entity == entity })
+			if i < 0 {
+				return nil, errNotFound("No ACL entry for entity %s", entity)
+			}
+
+			return slices.Delete(entries, i, i+1), nil
+		})
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		w.WriteHeader(http.StatusNoContent)
+	}
+}
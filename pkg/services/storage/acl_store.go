@@ -0,0 +1,122 @@
+package storage
+
+import (
+	"slices"
+	"sync"
+)
+
+// A legacy ACL entry, granting a role to an entity such as `user-jane@example.com`
+type aclEntry struct {
+	Entity string
+	Role   string
+}
+
+// The three ACL collections the JSON API exposes
+type aclKind int
+
+const (
+	bucketACL aclKind = iota
+	defaultObjectACL
+	objectACL
+)
+
+// Identifies one ACL. Object ACLs belong to a single generation.
+type aclKey struct {
+	Kind       aclKind
+	Bucket     string
+	Object     string
+	Generation int64
+}
+
+// Keeps legacy ACLs for the lifetime of the MinIO container. Buckets
+// without ACLs of their own are project-private. Objects written through
+// glocal get a copy of their bucket's default object ACL, and those written
+// through S3 have whatever default object ACL their bucket has.
+type aclStore struct {
+	acls map[aclKey][]aclEntry
+	mu   sync.RWMutex
+}
+
+func newACLStore() *aclStore {
+	return &aclStore{
+		acls: make(map[aclKey][]aclEntry),
+	}
+}
+
+// Returns a copy of the ACL, falling back to the defaults described above
+func (as *aclStore) Get(key aclKey) []aclEntry {
+	as.mu.RLock()
+	defer as.mu.RUnlock()
+
+	return as.get(key)
+}
+
+func (as *aclStore) get(key aclKey) []aclEntry {
+	for {
+		if entries, exists := as.acls[key]; exists {
+			return slices.Clone(entries)
+		}
+
+		switch key.Kind {
+		case objectACL:
+			key = aclKey{Kind: defaultObjectACL, Bucket: key.Bucket}
+		case defaultObjectACL:
+			return slices.Clone(predefinedObjectACLs["projectPrivate"])
+		default:
+			return slices.Clone(predefinedBucketACLs["projectPrivate"])
+		}
+	}
+}
+
+func (as *aclStore) Put(key aclKey, entries []aclEntry) {
+	as.mu.Lock()
+	defer as.mu.Unlock()
+
+	as.acls[key] = slices.Clone(entries)
+}
+
+// Applies fn to the ACL. Nothing is changed when fn returns an error.
+func (as *aclStore) Update(key aclKey, fn func(entries []aclEntry) ([]aclEntry, error)) ([]aclEntry, error) {
+	as.mu.Lock()
+	defer as.mu.Unlock()
+
+	entries, err := fn(as.get(key))
+	if err != nil {
+		return nil, err
+	}
+
+	as.acls[key] = entries
+
+	return slices.Clone(entries), nil
+}
+
+func (as *aclStore) Delete(key aclKey) {
+	as.mu.Lock()
+	defer as.mu.Unlock()
+
+	delete(as.acls, key)
+}
+
+// Drops the ACLs of every generation of an object but the one to keep
+func (as *aclStore) DeleteObject(bucket, name string, keep int64) {
+	as.mu.Lock()
+	defer as.mu.Unlock()
+
+	for key := range as.acls {
+		if key.Kind == objectACL && key.Bucket == bucket && key.Object == name && key.Generation != keep {
+			delete(as.acls, key)
+		}
+	}
+}
+
+// Drops every ACL of a bucket and its objects
+func (as *aclStore) DeleteBucket(bucket string) {
+	as.mu.Lock()
+	defer as.mu.Unlock()
+
+	for key := range as.acls {
+		if key.Bucket == bucket {
+			delete(as.acls, key)
+		}
+	}
+}
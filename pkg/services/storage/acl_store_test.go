@@ -0,0 +1,57 @@
+package storage
+
+import (
+	"slices"
+	"testing"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+func TestObjectACLLifetime(t *testing.T) {
+	s := &StorageService{buckets: newBucketStore(), acls: newACLStore()}
+	s.buckets.Put("bucket", &bucketAttrs{})
+
+	generation := func(gen int64) minio.ObjectInfo {
+		return minio.ObjectInfo{Key: "object", LastModified: time.UnixMicro(gen)}
+	}
+	first, second := generation(1_000_000), generation(2_000_000)
+
+	public := []aclEntry{{Entity: "allUsers", Role: "READER"}}
+	s.acls.Put(aclKey{Kind: defaultObjectACL, Bucket: "bucket"}, public)
+	s.initObjectACL("bucket", first, nil)
+
+	// Changing the default afterwards leaves existing objects alone
+	s.acls.Put(aclKey{Kind: defaultObjectACL, Bucket: "bucket"}, predefinedObjectACLs["private"])
+	if got := s.acls.Get(objectACLKey("bucket", first)); !slices.Equal(got, public) {
+		t.Errorf("object ACL after a default object ACL change = %v, want %v", got, public)
+	}
+
+	// Overwriting in an unversioned bucket drops the replaced generation's ACL
+	s.initObjectACL("bucket", second, nil)
+	if _, exists := s.acls.acls[objectACLKey("bucket", first)]; exists {
+		t.Error("ACL of an overwritten generation was kept")
+	}
+
+	s.removeObjectACL("bucket", second, false)
+	if _, exists := s.acls.acls[objectACLKey("bucket", second)]; exists {
+		t.Error("ACL of a deleted generation was kept")
+	}
+
+	// Versioned buckets keep deleted live generations as noncurrent versions
+	s.buckets.Put("bucket", &bucketAttrs{Versioning: true})
+	s.initObjectACL("bucket", first, nil)
+	s.initObjectACL("bucket", second, nil)
+	s.removeObjectACL("bucket", second, false)
+
+	for _, info := range []minio.ObjectInfo{first, second} {
+		if _, exists := s.acls.acls[objectACLKey("bucket", info)]; !exists {
+			t.Errorf("ACL of noncurrent generation %v was dropped", info.LastModified.UnixMicro())
+		}
+	}
+
+	s.removeObjectACL("bucket", first, true)
+	if _, exists := s.acls.acls[objectACLKey("bucket", first)]; exists {
+		t.Error("ACL of a generation removed for good was kept")
+	}
+}
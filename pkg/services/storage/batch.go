@@ -0,0 +1,149 @@
+package storage
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/textproto"
+	"strings"
+)
+
+// Largest number of calls GCS accepts in a single batch request
+const maxBatchSize = 100
+
+// Collects the response of a single call inside a batch
+type batchResponseWriter struct {
+	header http.Header
+	status int
+	body   bytes.Buffer
+}
+
+func newBatchResponseWriter() *batchResponseWriter {
+	return &batchResponseWriter{header: make(http.Header)}
+}
+
+func (w *batchResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *batchResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *batchResponseWriter) Write(p []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	return w.body.Write(p)
+}
+
+// Writes the collected response as an application/http part
+func (w *batchResponseWriter) writeTo(out io.Writer) error {
+	w.WriteHeader(http.StatusOK)
+
+	resp := &http.Response{
+		StatusCode:    w.status,
+		ProtoMajor:    1,
+		ProtoMinor:    1,
+		Header:        w.header,
+		Body:          io.NopCloser(&w.body),
+		ContentLength: int64(w.body.Len()),
+	}
+
+	return resp.Write(out)
+}
+
+// Parses one application/http part into a request served by this host
+func parseBatchPart(outer *http.Request, part io.Reader) (*http.Request, error) {
+	req, err := http.ReadRequest(bufio.NewReader(part))
+	if err != nil {
+		return nil, errBadRequest("Failed to parse batch request part: %v", err)
+	}
+
+	if strings.HasPrefix(req.URL.Path, "/batch/") {
+		return nil, errBadRequest("Nested batch requests are not supported")
+	}
+
+	req.Host = outer.Host
+	req.TLS = outer.TLS
+	req.RemoteAddr = outer.RemoteAddr
+	if proto := outer.Header.Get("X-Forwarded-Proto"); proto != "" {
+		req.Header.Set("X-Forwarded-Proto", proto)
+	}
+
+	// Parts act as the caller of the batch unless they say otherwise
+	for _, key := range []string{"Authorization", impersonateHeader} {
+		if value := outer.Header.Get(key); value != "" && req.Header.Get(key) == "" {
+			req.Header.Set(key, value)
+		}
+	}
+
+	return req.WithContext(outer.Context()), nil
+}
+
+// Handles a multipart/mixed batch by running every embedded call through the router
+func (s *StorageService) handleBatch(w http.ResponseWriter, r *http.Request) {
+	mediaType, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/mixed" || params["boundary"] == "" {
+		writeError(w, errBadRequest("Batch requests require a multipart/mixed body"))
+		return
+	}
+
+	reader := multipart.NewReader(r.Body, params["boundary"])
+
+	var responses bytes.Buffer
+	writer := multipart.NewWriter(&responses)
+
+	for count := 0; ; count++ {
+		part, err := reader.NextPart()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			writeError(w, errBadRequest("Failed to read batch request: %v", err))
+			return
+		}
+
+		if count == maxBatchSize {
+			writeError(w, errBadRequest("Batch requests may contain at most %d calls", maxBatchSize))
+			return
+		}
+
+		header := textproto.MIMEHeader{"Content-Type": {"application/http"}}
+		if id := part.Header.Get("Content-ID"); id != "" {
+			header.Set("Content-ID", "<response-"+strings.Trim(id, "<>")+">")
+		}
+
+		recorder := newBatchResponseWriter()
+
+		if req, err := parseBatchPart(r, part); err != nil {
+			writeError(recorder, err)
+		} else {
+			s.router.ServeHTTP(recorder, req)
+		}
+
+		out, err := writer.CreatePart(header)
+		if err != nil {
+			writeError(w, fmt.Errorf("failed to write batch response: %w", err))
+			return
+		}
+
+		if err := recorder.writeTo(out); err != nil {
+			writeError(w, fmt.Errorf("failed to write batch response: %w", err))
+			return
+		}
+	}
+
+	if err := writer.Close(); err != nil {
+		writeError(w, fmt.Errorf("failed to write batch response: %w", err))
+		return
+	}
+
+	w.Header().Set("Content-Type", "multipart/mixed; boundary="+writer.Boundary())
+	w.WriteHeader(http.StatusOK)
+	w.Write(responses.Bytes())
+}
@@ -0,0 +1,154 @@
+package storage
+
+import (
+	"maps"
+	"sync"
+	"time"
+)
+
+// GCS bucket attributes that MinIO has no equivalent for
+type bucketAttrs struct {
+	Location       string
+	StorageClass   string
+	Labels         map[string]string
+	Versioning     bool
+	Metageneration int64
+	Updated        time.Time
+
+	// Project the bucket was created in, which the default IAM policy grants access to
+	Project string
+	// IAM policy bindings, nil until a policy is set
+	IAMBindings []PolicyBinding
+	IAMVersion  int
+
+	// Whether access is controlled by IAM alone, and since when
+	UniformBucketLevelAccess     bool
+	UniformBucketLevelAccessTime time.Time
+
+	// Lifecycle rules the sweeper applies to the bucket's contents
+	Lifecycle []LifecycleRule
+
+	// CORS rules cross-origin requests addressing the bucket are checked against
+	CORS []BucketCors
+
+	// Seconds objects are retained for, zero without a retention policy.
+	// A locked policy can't be removed or reduced.
+	RetentionPeriod        int64
+	RetentionEffectiveTime time.Time
+	RetentionLocked        bool
+
+	// Whether new objects start out under an event-based hold
+	DefaultEventBasedHold bool
+	// Whether objects can carry retention configurations of their own
+	ObjectRetention bool
+
+	// Cloud KMS key recorded on new objects that don't name one
+	DefaultKMSKeyName string
+
+	// Seconds deleted and overwritten objects stay restorable for, zero with soft delete disabled
+	SoftDeleteRetention     int64
+	SoftDeleteEffectiveTime time.Time
+}
+
+// Keeps glocal-side bucket attributes for the lifetime of the MinIO container
+type bucketStore struct {
+	buckets map[string]*bucketAttrs
+	mu      sync.RWMutex
+}
+
+func newBucketStore() *bucketStore {
+	return &bucketStore{
+		buckets: make(map[string]*bucketAttrs),
+	}
+}
+
+func (bs *bucketStore) Put(name string, attrs *bucketAttrs) {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+
+	bs.buckets[name] = attrs
+}
+
+// Returns a copy of the stored attributes, falling back to
+// the given defaults for buckets created outside of glocal
+func (bs *bucketStore) Get(name string, defaults bucketAttrs) bucketAttrs {
+	bs.mu.RLock()
+	defer bs.mu.RUnlock()
+
+	attrs, exists := bs.buckets[name]
+	if !exists {
+		return defaults
+	}
+
+	result := *attrs
+	result.Labels = maps.Clone(attrs.Labels)
+
+	return result
+}
+
+// Applies fn to the bucket's attributes and bumps its metageneration.
+// Nothing is changed when fn returns an error.
+func (bs *bucketStore) Update(name string, defaults bucketAttrs, fn func(attrs *bucketAttrs) error) (bucketAttrs, error) {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+
+	current, exists := bs.buckets[name]
+	if !exists {
+		current = &defaults
+	}
+
+	attrs := *current
+	attrs.Labels = maps.Clone(current.Labels)
+
+	if err := fn(&attrs); err != nil {
+		return bucketAttrs{}, err
+	}
+
+	bs.buckets[name] = &attrs
+	attrs.Metageneration++
+	attrs.Updated = time.Now()
+
+	result := attrs
+	result.Labels = maps.Clone(attrs.Labels)
+
+	return result, nil
+}
+
+// Applies fn to a copy of the bucket's attributes without storing the result,
+// for changes that must be checked before anything outside the store is touched
+func (bs *bucketStore) Check(name string, defaults bucketAttrs, fn func(attrs *bucketAttrs) error) error {
+	bs.mu.RLock()
+	defer bs.mu.RUnlock()
+
+	current, exists := bs.buckets[name]
+	if !exists {
+		current = &defaults
+	}
+
+	attrs := *current
+	attrs.Labels = maps.Clone(current.Labels)
+
+	return fn(&attrs)
+}
+
+// Returns the lifecycle rules of every bucket that has some
+func (bs *bucketStore) Lifecycles() map[string][]LifecycleRule {
+	bs.mu.RLock()
+	defer bs.mu.RUnlock()
+
+	result := make(map[string][]LifecycleRule)
+	for name, attrs := range bs.buckets {
+		if len(attrs.Lifecycle) > 0 {
+			result[name] = attrs.Lifecycle
+		}
+	}
+
+	return result
+}
+
+func (bs *bucketStore) Delete(name string) {
+	bs.mu.Lock()
+	defer bs.mu.Unlock()
+
+	delete(bs.buckets, name)
+}
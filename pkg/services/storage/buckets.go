@@ -0,0 +1,618 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"go.uber.org/zap"
+)
+
+type bucketPatch struct {
+	StorageClass     *string                 `json:"storageClass"`
+	Labels           map[string]*string      `json:"labels"`
+	Versioning       *BucketVersioning       `json:"versioning"`
+	IamConfiguration *BucketIamConfiguration `json:"iamConfiguration"`
+	Lifecycle        *BucketLifecycle        `json:"lifecycle"`
+	Acl              []*AccessControl        `json:"acl"`
+	DefaultObjectAcl []*AccessControl        `json:"defaultObjectAcl"`
+
+	// An empty list removes the CORS rules, while a missing one leaves them alone
+	Cors []BucketCors `json:"cors"`
+
+	// Kept raw to tell a null policy, which removes it, from a missing one
+	RetentionPolicy       json.RawMessage `json:"retentionPolicy"`
+	DefaultEventBasedHold *bool           `json:"defaultEventBasedHold"`
+
+	// A null or empty encryption config removes the default KMS key
+	Encryption json.RawMessage `json:"encryption"`
+
+	// A zero retention duration turns soft delete off
+	SoftDeletePolicy *BucketSoftDeletePolicy `json:"softDeletePolicy"`
+}
+
+// Uniform bucket-level access can only be disabled this long after it was enabled
+const uniformAccessLockPeriod = 90 * 24 * time.Hour
+
+// Reads the requested uniform bucket-level access setting under either of its names
+func (c *BucketIamConfiguration) uniformAccess() *bool {
+	if c == nil {
+		return nil
+	}
+
+	if c.UniformBucketLevelAccess != nil {
+		return &c.UniformBucketLevelAccess.Enabled
+	}
+	if c.BucketPolicyOnly != nil {
+		return &c.BucketPolicyOnly.Enabled
+	}
+
+	return nil
+}
+
+// Switches uniform bucket-level access, which can't be undone once locked
+func (attrs *bucketAttrs) setUniformAccess(enabled bool) error {
+	switch {
+	case enabled && !attrs.UniformBucketLevelAccess:
+		attrs.UniformBucketLevelAccessTime = time.Now()
+	case !enabled && attrs.UniformBucketLevelAccess:
+		if time.Since(attrs.UniformBucketLevelAccessTime) > uniformAccessLockPeriod {
+			return errBadRequest("Cannot disable uniform bucket-level access after it has been locked.")
+		}
+		attrs.UniformBucketLevelAccessTime = time.Time{}
+	}
+
+	attrs.UniformBucketLevelAccess = enabled
+
+	return nil
+}
+
+// Reads the ACLs a bucket insert or patch sets through `predefinedAcl`,
+// `predefinedDefaultObjectAcl` or the resource, keyed by the collection they replace
+func parseBucketACLs(r *http.Request, resource *Bucket) (map[aclKind][]aclEntry, error) {
+	acls := make(map[aclKind][]aclEntry)
+
+	for _, param := range []struct {
+		name string
+		kind aclKind
+		body []*AccessControl
+	}{
+		{"predefinedAcl", bucketACL, resource.Acl},
+		{"predefinedDefaultObjectAcl", defaultObjectACL, resource.DefaultObjectAcl},
+	} {
+		if name := r.URL.Query().Get(param.name); name != "" {
+			entries, err := parsePredefinedACL(param.name, name, param.kind)
+			if err != nil {
+				return nil, err
+			}
+			acls[param.kind] = entries
+			continue
+		}
+
+		if param.body == nil {
+			continue
+		}
+
+		entries := make([]aclEntry, 0, len(param.body))
+		for _, acl := range param.body {
+			entry := aclEntry{Entity: acl.Entity, Role: acl.Role}
+			if err := validateACLEntry(param.kind, entry); err != nil {
+				return nil, err
+			}
+			entries = append(entries, entry)
+		}
+		acls[param.kind] = entries
+	}
+
+	return acls, nil
+}
+
+// Rejects ACLs on buckets with uniform bucket-level access
+func checkBucketACLs(attrs bucketAttrs, acls map[aclKind][]aclEntry, action string) error {
+	if !attrs.UniformBucketLevelAccess {
+		return nil
+	}
+
+	for kind := range acls {
+		return errUniformBucketLevelAccess(action, kind)
+	}
+
+	return nil
+}
+
+func (s *StorageService) defaultBucketAttrs(created time.Time) bucketAttrs {
+	return bucketAttrs{
+		Location:       s.config.DefaultLocation,
+		StorageClass:   "STANDARD",
+		Metageneration: 1,
+		Updated:        created,
+	}
+}
+
+// Switches MinIO versioning on or off. MinIO can only suspend versioning
+// once enabled, which behaves the same as GCS disabling it.
+func (s *StorageService) setBucketVersioning(ctx context.Context, name string, enabled bool) error {
+	if enabled {
+		return s.client.EnableVersioning(ctx, name)
+	}
+
+	return s.client.SuspendVersioning(ctx, name)
+}
+
+// Applies a change to a bucket's attributes that may switch its versioning. MinIO is only
+// switched once the change is known to apply, and outside the store's lock so that bucket
+// reads don't wait on the round trip.
+func (s *StorageService) updateBucket(ctx context.Context, info minio.BucketInfo, versioning *bool, apply func(attrs *bucketAttrs) error) (bucketAttrs, error) {
+	defaults := s.defaultBucketAttrs(info.CreationDate)
+
+	if err := s.buckets.Check(info.Name, defaults, apply); err != nil {
+		return bucketAttrs{}, err
+	}
+
+	previous := s.buckets.Get(info.Name, defaults).Versioning
+	if versioning != nil {
+		if err := s.setBucketVersioning(ctx, info.Name, *versioning); err != nil {
+			return bucketAttrs{}, err
+		}
+	}
+
+	attrs, err := s.buckets.Update(info.Name, defaults, apply)
+	if err != nil {
+		// The bucket changed in the meantime, so MinIO goes back to how it was
+		if versioning != nil && *versioning != previous {
+			if err := s.setBucketVersioning(context.WithoutCancel(ctx), info.Name, previous); err != nil {
+				s.logger.Warn("Failed to restore bucket versioning", zap.String("bucket", info.Name), zap.Error(err))
+			}
+		}
+
+		return bucketAttrs{}, err
+	}
+
+	return attrs, nil
+}
+
+// Applies the MinIO side of a bucket insert to a freshly made bucket and returns it
+func (s *StorageService) setupBucket(ctx context.Context, name string, versioning bool) (minio.BucketInfo, error) {
+	if versioning {
+		if err := s.setBucketVersioning(ctx, name, true); err != nil {
+			return minio.BucketInfo{}, err
+		}
+	}
+
+	return s.lookupBucket(ctx, name)
+}
+
+// Looks up a bucket in MinIO, since S3 has no single-bucket GET carrying the creation date
+func (s *StorageService) lookupBucket(ctx context.Context, name string) (minio.BucketInfo, error) {
+	buckets, err := s.client.ListBuckets(ctx)
+	if err != nil {
+		return minio.BucketInfo{}, err
+	}
+
+	for _, bucket := range buckets {
+		if bucket.Name == name && name != softDeleteBucket {
+			return bucket, nil
+		}
+	}
+
+	return minio.BucketInfo{}, errNotFound("The specified bucket does not exist.")
+}
+
+func (s *StorageService) renderBucket(r *http.Request, info minio.BucketInfo, attrs bucketAttrs) *Bucket {
+	locationType := "region"
+	if attrs.Location == "US" || attrs.Location == "EU" || attrs.Location == "ASIA" {
+		locationType = "multi-region"
+	}
+
+	var versioning *BucketVersioning
+	if attrs.Versioning {
+		versioning = &BucketVersioning{Enabled: true}
+	}
+
+	uniformAccess := &UniformBucketLevelAccess{Enabled: attrs.UniformBucketLevelAccess}
+	if attrs.UniformBucketLevelAccess {
+		uniformAccess.LockedTime = formatTime(attrs.UniformBucketLevelAccessTime.Add(uniformAccessLockPeriod))
+	}
+
+	bucket := &Bucket{
+		Kind:           "storage#bucket",
+		ID:             info.Name,
+		SelfLink:       bucketSelfLink(r, info.Name),
+		ProjectNumber:  projectNumber,
+		Name:           info.Name,
+		TimeCreated:    formatTime(info.CreationDate),
+		Updated:        formatTime(attrs.Updated),
+		Metageneration: attrs.Metageneration,
+		Location:       attrs.Location,
+		LocationType:   locationType,
+		StorageClass:   attrs.StorageClass,
+		Etag:           metagenerationEtag(attrs.Metageneration),
+		Labels:         attrs.Labels,
+		Versioning:     versioning,
+		IamConfiguration: &BucketIamConfiguration{
+			UniformBucketLevelAccess: uniformAccess,
+			BucketPolicyOnly:         uniformAccess,
+		},
+	}
+
+	if len(attrs.Lifecycle) > 0 {
+		bucket.Lifecycle = &BucketLifecycle{Rule: attrs.Lifecycle}
+	}
+
+	bucket.Cors = attrs.CORS
+
+	if attrs.RetentionPeriod > 0 {
+		bucket.RetentionPolicy = &BucketRetentionPolicy{
+			RetentionPeriod: attrs.RetentionPeriod,
+			EffectiveTime:   formatTime(attrs.RetentionEffectiveTime),
+			IsLocked:        attrs.RetentionLocked,
+		}
+	}
+
+	bucket.DefaultEventBasedHold = attrs.DefaultEventBasedHold
+	if attrs.ObjectRetention {
+		bucket.ObjectRetention = &BucketObjectRetention{Mode: "Enabled"}
+	}
+
+	if attrs.DefaultKMSKeyName != "" {
+		bucket.Encryption = &BucketEncryption{DefaultKmsKeyName: attrs.DefaultKMSKeyName}
+	}
+
+	if attrs.SoftDeleteRetention > 0 {
+		bucket.SoftDeletePolicy = &BucketSoftDeletePolicy{
+			RetentionDurationSeconds: attrs.SoftDeleteRetention,
+			EffectiveTime:            formatTime(attrs.SoftDeleteEffectiveTime),
+		}
+	}
+
+	if r.URL.Query().Get("projection") == "full" && !attrs.UniformBucketLevelAccess {
+		etag := metagenerationEtag(attrs.Metageneration)
+
+		bucketTarget := aclTarget{key: aclKey{Kind: bucketACL, Bucket: info.Name}, etag: etag}
+		bucket.Acl = renderAccessControls(r, bucketTarget, s.acls.Get(bucketTarget.key))
+
+		defaultTarget := aclTarget{key: aclKey{Kind: defaultObjectACL, Bucket: info.Name}, etag: etag}
+		bucket.DefaultObjectAcl = renderAccessControls(r, defaultTarget, s.acls.Get(defaultTarget.key))
+	}
+
+	return bucket
+}
+
+func (s *StorageService) handleListBuckets(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	prefix := query.Get("prefix")
+
+	maxResults, err := parseMaxResults(query.Get("maxResults"), 1000)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	startAfter, err := decodePageToken(query.Get("pageToken"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	buckets, err := s.client.ListBuckets(r.Context())
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	result := &Buckets{
+		Kind:  "storage#buckets",
+		Items: []*Bucket{},
+	}
+
+	for _, info := range buckets {
+		if !strings.HasPrefix(info.Name, prefix) || info.Name <= startAfter || info.Name == softDeleteBucket {
+			continue
+		}
+
+		if len(result.Items) == maxResults {
+			result.NextPageToken = encodePageToken(result.Items[len(result.Items)-1].Name)
+			break
+		}
+
+		attrs := s.buckets.Get(info.Name, s.defaultBucketAttrs(info.CreationDate))
+		result.Items = append(result.Items, s.renderBucket(r, info, attrs))
+	}
+
+	writeJSON(w, http.StatusOK, result)
+}
+
+func (s *StorageService) handleInsertBucket(w http.ResponseWriter, r *http.Request) {
+	var req Bucket
+	if err := readJSON(r, &req); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if req.Name == "" {
+		writeError(w, errBadRequest("Required parameter: name"))
+		return
+	}
+
+	if req.Name == softDeleteBucket {
+		writeError(w, errConflict("The requested bucket name is not available. The bucket namespace is shared by all users of the system. Please select a different name and try again."))
+		return
+	}
+
+	attrs := s.defaultBucketAttrs(time.Time{})
+	if req.Location != "" {
+		attrs.Location = strings.ToUpper(req.Location)
+	}
+	if req.StorageClass != "" {
+		attrs.StorageClass = req.StorageClass
+	}
+	attrs.Labels = req.Labels
+	attrs.Project = r.URL.Query().Get("project")
+
+	lifecycle, err := validateLifecycle(req.Lifecycle)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	attrs.Lifecycle = lifecycle
+
+	if err := validateCORS(req.Cors); err != nil {
+		writeError(w, err)
+		return
+	}
+	attrs.CORS = req.Cors
+
+	if err := attrs.setRetentionPolicy(req.RetentionPolicy); err != nil {
+		writeError(w, err)
+		return
+	}
+	if attrs.RetentionPeriod > 0 && req.Versioning != nil && req.Versioning.Enabled {
+		writeError(w, errVersionedRetention())
+		return
+	}
+	attrs.DefaultEventBasedHold = req.DefaultEventBasedHold
+	attrs.ObjectRetention = r.URL.Query().Get("enableObjectRetention") == "true"
+
+	if req.Encryption != nil {
+		attrs.DefaultKMSKeyName = req.Encryption.DefaultKmsKeyName
+	}
+
+	if err := attrs.setSoftDeletePolicy(req.SoftDeletePolicy); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if enabled := req.IamConfiguration.uniformAccess(); enabled != nil {
+		if err := attrs.setUniformAccess(*enabled); err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+
+	acls, err := parseBucketACLs(r, &req)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if err := checkBucketACLs(attrs, acls, "insert"); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if err := s.client.MakeBucket(r.Context(), req.Name, minio.MakeBucketOptions{}); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	info, err := s.setupBucket(r.Context(), req.Name, req.Versioning != nil && req.Versioning.Enabled)
+	if err != nil {
+		// Don't leave behind a bucket the client was told wasn't created
+		if err := s.client.RemoveBucket(r.Context(), req.Name); err != nil {
+			s.logger.Warn("Failed to remove bucket after a failed insert", zap.String("bucket", req.Name), zap.Error(err))
+		}
+		writeError(w, err)
+		return
+	}
+	attrs.Versioning = req.Versioning != nil && req.Versioning.Enabled
+
+	attrs.Updated = info.CreationDate
+	s.buckets.Put(req.Name, &attrs)
+
+	for kind, entries := range acls {
+		s.acls.Put(aclKey{Kind: kind, Bucket: req.Name}, entries)
+	}
+
+	s.syncServiceAccountPolicies(r.Context())
+
+	writeJSON(w, http.StatusOK, s.renderBucket(r, info, attrs))
+}
+
+func (s *StorageService) handleGetBucket(w http.ResponseWriter, r *http.Request) {
+	info, err := s.lookupBucket(r.Context(), r.PathValue("bucket"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	attrs := s.buckets.Get(info.Name, s.defaultBucketAttrs(info.CreationDate))
+	writeJSON(w, http.StatusOK, s.renderBucket(r, info, attrs))
+}
+
+func (s *StorageService) handlePatchBucket(w http.ResponseWriter, r *http.Request) {
+	info, err := s.lookupBucket(r.Context(), r.PathValue("bucket"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	var patch bucketPatch
+	if err := readJSON(r, &patch); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	acls, err := parseBucketACLs(r, &Bucket{Acl: patch.Acl, DefaultObjectAcl: patch.DefaultObjectAcl})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	lifecycle, err := validateLifecycle(patch.Lifecycle)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if err := validateCORS(patch.Cors); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	var retentionPolicy *BucketRetentionPolicy
+	if patch.RetentionPolicy != nil {
+		if err := json.Unmarshal(patch.RetentionPolicy, &retentionPolicy); err != nil {
+			writeError(w, errBadRequest("Invalid value for retentionPolicy: %s", patch.RetentionPolicy))
+			return
+		}
+	}
+
+	var encryption *BucketEncryption
+	if patch.Encryption != nil {
+		if err := json.Unmarshal(patch.Encryption, &encryption); err != nil {
+			writeError(w, errBadRequest("Invalid value for encryption: %s", patch.Encryption))
+			return
+		}
+	}
+
+	apply := func(attrs *bucketAttrs) error {
+		if patch.Versioning != nil {
+			attrs.Versioning = patch.Versioning.Enabled
+		}
+
+		if patch.StorageClass != nil {
+			attrs.StorageClass = *patch.StorageClass
+		}
+
+		if patch.Lifecycle != nil {
+			attrs.Lifecycle = lifecycle
+		}
+
+		if patch.Cors != nil {
+			attrs.CORS = nil
+			if len(patch.Cors) > 0 {
+				attrs.CORS = patch.Cors
+			}
+		}
+
+		if patch.RetentionPolicy != nil {
+			if err := attrs.setRetentionPolicy(retentionPolicy); err != nil {
+				return err
+			}
+		}
+
+		if patch.DefaultEventBasedHold != nil {
+			attrs.DefaultEventBasedHold = *patch.DefaultEventBasedHold
+		}
+
+		if patch.SoftDeletePolicy != nil {
+			if err := attrs.setSoftDeletePolicy(patch.SoftDeletePolicy); err != nil {
+				return err
+			}
+		}
+
+		if patch.Encryption != nil {
+			attrs.DefaultKMSKeyName = ""
+			if encryption != nil {
+				attrs.DefaultKMSKeyName = encryption.DefaultKmsKeyName
+			}
+		}
+
+		for key, value := range patch.Labels {
+			if attrs.Labels == nil {
+				attrs.Labels = make(map[string]string)
+			}
+
+			if value == nil {
+				delete(attrs.Labels, key)
+			} else {
+				attrs.Labels[key] = *value
+			}
+		}
+
+		if enabled := patch.IamConfiguration.uniformAccess(); enabled != nil {
+			if err := attrs.setUniformAccess(*enabled); err != nil {
+				return err
+			}
+		}
+
+		if attrs.Versioning && attrs.RetentionPeriod > 0 {
+			return errVersionedRetention()
+		}
+
+		return checkBucketACLs(*attrs, acls, "update")
+	}
+
+	var versioning *bool
+	if patch.Versioning != nil {
+		versioning = &patch.Versioning.Enabled
+	}
+
+	attrs, err := s.updateBucket(r.Context(), info, versioning, apply)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	for kind, entries := range acls {
+		s.acls.Put(aclKey{Kind: kind, Bucket: info.Name}, entries)
+	}
+
+	writeJSON(w, http.StatusOK, s.renderBucket(r, info, attrs))
+}
+
+func (s *StorageService) handleDeleteBucket(w http.ResponseWriter, r *http.Request) {
+	name := r.PathValue("bucket")
+
+	if _, err := s.lookupBucket(r.Context(), name); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if err := s.client.RemoveBucket(r.Context(), name); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	// Soft-deleted objects go along with their bucket
+	if err := s.purgeSoftDeleted(r.Context(), name, time.Time{}); err != nil {
+		s.logger.Warn("Failed to remove soft-deleted objects", zap.String("bucket", name), zap.Error(err))
+	}
+
+	s.buckets.Delete(name)
+	s.acls.DeleteBucket(name)
+	s.notifications.DeleteBucket(name)
+	s.syncServiceAccountPolicies(r.Context())
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func parseMaxResults(value string, limit int) (int, error) {
+	if value == "" {
+		return limit, nil
+	}
+
+	maxResults, err := strconv.Atoi(value)
+	if err != nil || maxResults < 0 {
+		return 0, errBadRequest("Invalid value for maxResults: %s", value)
+	}
+
+	if maxResults == 0 || maxResults > limit {
+		return limit, nil
+	}
+
+	return maxResults, nil
+}
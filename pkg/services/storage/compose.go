@@ -0,0 +1,150 @@
+package storage
+
+import (
+	"io"
+	"net/http"
+	"strconv"
+)
+
+const (
+	maxComposeSources = 32
+	maxComponentCount = 1024
+)
+
+type composeSource struct {
+	Name                string `json:"name"`
+	Generation          int64  `json:"generation,string"`
+	ObjectPreconditions *struct {
+		IfGenerationMatch *int64 `json:"ifGenerationMatch,string"`
+	} `json:"objectPreconditions"`
+}
+
+type composeRequest struct {
+	Destination   *Object         `json:"destination"`
+	SourceObjects []composeSource `json:"sourceObjects"`
+}
+
+// Handles compose by concatenating the sources on glocal's side. S3 UploadPartCopy
+// would need every source but the last to be at least 5MiB, which composite
+// upload components often aren't.
+func (s *StorageService) handleComposeObject(w http.ResponseWriter, r *http.Request) {
+	bucket := r.PathValue("bucket")
+	name := r.PathValue("object")
+
+	var req composeRequest
+	if err := readJSON(r, &req); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if len(req.SourceObjects) == 0 {
+		writeError(w, errBadRequest("Required field: sourceObjects"))
+		return
+	}
+	if len(req.SourceObjects) > maxComposeSources {
+		writeError(w, errBadRequest("The number of source components provided (%d) exceeds the maximum (%d)",
+			len(req.SourceObjects), maxComposeSources))
+		return
+	}
+
+	conds, err := parsePreconditions(r.URL.Query())
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	acl, err := s.parsePredefinedObjectACL(bucket, "destinationPredefinedAcl", r.URL.Query().Get("destinationPredefinedAcl"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	// Like GCS, the key encrypting the destination must also be the one every source is encrypted with
+	key, err := parseCustomerKey(r.Header, encryptionHeaderPrefix)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	componentCount := 0
+	readers := make([]io.Reader, 0, len(req.SourceObjects))
+
+	for _, source := range req.SourceObjects {
+		if source.Name == "" {
+			writeError(w, errBadRequest("Required field: sourceObjects.name"))
+			return
+		}
+
+		generation := ""
+		if source.Generation != 0 {
+			generation = strconv.FormatInt(source.Generation, 10)
+		}
+
+		info, err := s.statGeneration(r.Context(), bucket, source.Name, generation)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		attrs := parseObjectAttrs(info)
+
+		if pre := source.ObjectPreconditions; pre != nil && pre.IfGenerationMatch != nil && *pre.IfGenerationMatch != attrs.Generation {
+			writeError(w, errPreconditionFailed())
+			return
+		}
+
+		componentCount += max(attrs.ComponentCount, 1)
+
+		object, err := s.openObject(r.Context(), bucket, info, key)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+		defer object.Close()
+
+		readers = append(readers, object)
+	}
+
+	if componentCount > maxComponentCount {
+		writeError(w, errBadRequest("The number of components in the composed object (%d) exceeds the maximum (%d)",
+			componentCount, maxComponentCount))
+		return
+	}
+
+	data, err := spoolUpload(io.MultiReader(readers...))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	defer data.Close()
+
+	var attrs objectAttrs
+	if req.Destination != nil {
+		attrs, err = attrsFromResource(req.Destination)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+	attrs.ComponentCount = componentCount
+	if attrs.KMSKeyName == "" {
+		attrs.KMSKeyName = r.URL.Query().Get("kmsKeyName")
+	}
+
+	unlock, err := s.guardOverwrite(r.Context(), bucket, name, conds)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	defer unlock()
+
+	info, err := s.writeObject(r.Context(), bucket, name, data, attrs, key)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	s.initObjectACL(bucket, info, acl)
+
+	writeJSON(w, http.StatusOK, s.renderObject(r, bucket, info))
+}
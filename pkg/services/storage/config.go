@@ -0,0 +1,71 @@
+package storage
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/go-viper/mapstructure/v2"
+)
+
+// Settings read from the `services.storage.config` block
+type Config struct {
+	AccessKey string `mapstructure:"access_key"`
+	SecretKey string `mapstructure:"secret_key"`
+
+	// Location reported for buckets created without an explicit location
+	DefaultLocation string `mapstructure:"default_location"`
+
+	// How long a resumable upload session URI stays valid
+	ResumableSessionTTL time.Duration `mapstructure:"resumable_session_ttl"`
+
+	// Service account JSON key that V4 signed URLs using GOOG4-RSA-SHA256 are verified against
+	SigningKeyFile string `mapstructure:"signing_key_file"`
+
+	// HMAC access IDs and their secrets, for signed URLs using GOOG4-HMAC-SHA256
+	HMACKeys map[string]string `mapstructure:"hmac_keys"`
+
+	// Checks callers against bucket IAM policies. Anonymous callers only get what allUsers is granted.
+	EnforceIAM bool `mapstructure:"enforce_iam"`
+
+	// How often bucket lifecycle rules are applied
+	LifecycleInterval time.Duration `mapstructure:"lifecycle_interval"`
+
+	// How long a day lasts for lifecycle conditions, shortened to exercise age-based rules quickly
+	LifecycleDayLength time.Duration `mapstructure:"lifecycle_day_length"`
+
+	// Pub/Sub REST endpoint object change notifications are published to, such as
+	// `http://localhost:8085` for the Pub/Sub emulator. glocal has no Pub/Sub service of
+	// its own, so notifications are only logged and dropped while it is unset.
+	PubSubEndpoint string `mapstructure:"pubsub_endpoint"`
+}
+
+func ParseConfig(raw map[string]any) (Config, error) {
+	cfg := Config{
+		AccessKey:           "minioadmin",
+		SecretKey:           "minioadmin",
+		DefaultLocation:     "US",
+		ResumableSessionTTL: 7 * 24 * time.Hour,
+		LifecycleInterval:   time.Minute,
+		LifecycleDayLength:  24 * time.Hour,
+	}
+
+	decoder, err := mapstructure.NewDecoder(&mapstructure.DecoderConfig{
+		Result:           &cfg,
+		WeaklyTypedInput: true,
+		DecodeHook:       mapstructure.StringToTimeDurationHookFunc(),
+	})
+	if err != nil {
+		return Config{}, fmt.Errorf("failed to create config decoder: %w", err)
+	}
+
+	if err := decoder.Decode(raw); err != nil {
+		return Config{}, fmt.Errorf("failed to decode storage config: %w", err)
+	}
+
+	if cfg.LifecycleInterval <= 0 || cfg.LifecycleDayLength <= 0 {
+		return Config{}, errors.New("lifecycle_interval and lifecycle_day_length must be positive")
+	}
+
+	return cfg, nil
+}
@@ -0,0 +1,347 @@
+package storage
+
+import (
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// Granularity GCS requires for maxBytesRewrittenPerCall
+const rewriteChunkSize = 1 << 20
+
+// Applies the fields a copy request sets on top of the source object's attributes
+func mergeObjectAttrs(attrs *objectAttrs, obj *Object) error {
+	if obj.ContentType != "" {
+		attrs.ContentType = obj.ContentType
+	}
+	if obj.ContentEncoding != "" {
+		attrs.ContentEncoding = obj.ContentEncoding
+	}
+	if obj.ContentDisposition != "" {
+		attrs.ContentDisposition = obj.ContentDisposition
+	}
+	if obj.ContentLanguage != "" {
+		attrs.ContentLanguage = obj.ContentLanguage
+	}
+	if obj.CacheControl != "" {
+		attrs.CacheControl = obj.CacheControl
+	}
+	if obj.StorageClass != "" {
+		attrs.StorageClass = obj.StorageClass
+	}
+	if obj.KmsKeyName != "" {
+		attrs.KMSKeyName = obj.KmsKeyName
+	}
+	if obj.Metadata != nil {
+		attrs.Metadata = obj.Metadata
+	}
+	if obj.TemporaryHold != nil {
+		attrs.TemporaryHold = *obj.TemporaryHold
+	}
+	if obj.EventBasedHold != nil {
+		attrs.EventBasedHold = *obj.EventBasedHold
+	}
+	if obj.Retention != nil {
+		if err := attrs.setRetention(obj.Retention); err != nil {
+			return err
+		}
+	}
+	if obj.CustomTime != "" {
+		return attrs.setCustomTime(obj.CustomTime)
+	}
+
+	return nil
+}
+
+// Looks up the source of a copy and checks the source preconditions against it
+func (s *StorageService) statCopySource(r *http.Request) (minio.ObjectInfo, error) {
+	query := r.URL.Query()
+
+	srcConds, err := parseSourcePreconditions(query)
+	if err != nil {
+		return minio.ObjectInfo{}, err
+	}
+
+	info, err := s.statGeneration(r.Context(), r.PathValue("bucket"), r.PathValue("object"), query.Get("sourceGeneration"))
+	if err != nil {
+		return minio.ObjectInfo{}, err
+	}
+
+	if err := srcConds.check(true, parseObjectAttrs(info)); err != nil {
+		return minio.ObjectInfo{}, err
+	}
+
+	return info, nil
+}
+
+// Copies a source version into a new generation of the destination, server-side unless
+// it has to be encrypted differently. The source is read with srcKey, and the copy encrypted with dstKey.
+func (s *StorageService) copyObject(ctx context.Context, srcBucket string, src minio.ObjectInfo, dstBucket, dstName string,
+	override *Object, conds preconditions, srcKey, dstKey *customerKey) (minio.ObjectInfo, error) {
+	attrs := parseObjectAttrs(src)
+	if err := checkCustomerKey(attrs, srcKey); err != nil {
+		return minio.ObjectInfo{}, err
+	}
+
+	// Copies get the destination bucket's default storage class and KMS key unless the request names them
+	attrs.StorageClass = ""
+	attrs.KMSKeyName = ""
+	// Holds and retention protect the source version, not its copies
+	attrs.TemporaryHold = false
+	attrs.EventBasedHold = false
+	attrs.HoldReleased = time.Time{}
+	attrs.RetentionMode = ""
+	attrs.RetainUntil = time.Time{}
+	// Restoring a soft-deleted generation copies it back as a live one
+	attrs.SoftDeleted = time.Time{}
+	attrs.HardDeleted = time.Time{}
+	if err := mergeObjectAttrs(&attrs, override); err != nil {
+		return minio.ObjectInfo{}, err
+	}
+
+	if err := s.applyBucketRetention(dstBucket, &attrs); err != nil {
+		return minio.ObjectInfo{}, err
+	}
+
+	unlock, err := s.guardOverwrite(ctx, dstBucket, dstName, conds)
+	if err != nil {
+		return minio.ObjectInfo{}, err
+	}
+	defer unlock()
+
+	// Taken under the object lock, so that it's newer than the generation being replaced
+	attrs.startGeneration()
+
+	// Data encrypted with one key has to pass through glocal to end up under another
+	if attrs.KeySHA256 != dstKey.digest() {
+		return s.recryptObject(ctx, srcBucket, src, srcKey, dstBucket, dstName, attrs, dstKey)
+	}
+
+	if err := s.resolveKMSKey(dstBucket, &attrs, dstKey); err != nil {
+		return minio.ObjectInfo{}, err
+	}
+
+	replaced, undo, err := s.replacedVersion(ctx, dstBucket, dstName)
+	if err != nil {
+		return minio.ObjectInfo{}, err
+	}
+
+	// Composing copies sources over 5 GiB part by part, where a plain copy would fail
+	uploaded, err := s.client.ComposeObject(ctx, attrs.copyDestOptions(dstBucket, dstName), minio.CopySrcOptions{
+		Bucket:    srcBucket,
+		Object:    src.Key,
+		VersionID: src.VersionID,
+	})
+	if err != nil {
+		undo()
+		return minio.ObjectInfo{}, err
+	}
+
+	info, err := s.client.StatObject(ctx, dstBucket, dstName, minio.StatObjectOptions{
+		VersionID: uploaded.VersionID,
+		Checksum:  true,
+	})
+	if err != nil {
+		return minio.ObjectInfo{}, err
+	}
+
+	s.notifyFinalize(dstBucket, replaced, info)
+
+	return info, nil
+}
+
+// Copies a source version by reading it through glocal, decrypting and encrypting it as the keys require
+func (s *StorageService) recryptObject(ctx context.Context, srcBucket string, src minio.ObjectInfo, srcKey *customerKey,
+	dstBucket, dstName string, attrs objectAttrs, dstKey *customerKey) (minio.ObjectInfo, error) {
+	object, err := s.openObject(ctx, srcBucket, src, srcKey)
+	if err != nil {
+		return minio.ObjectInfo{}, err
+	}
+	defer object.Close()
+
+	data, err := spoolUpload(object)
+	if err != nil {
+		return minio.ObjectInfo{}, err
+	}
+	defer data.Close()
+
+	return s.writeObject(ctx, dstBucket, dstName, data, attrs, dstKey)
+}
+
+func (s *StorageService) handleCopyObject(w http.ResponseWriter, r *http.Request) {
+	var override Object
+	if err := readJSON(r, &override); err != nil {
+		writeError(w, err)
+		return
+	}
+	if override.KmsKeyName == "" {
+		override.KmsKeyName = r.URL.Query().Get("destinationKmsKeyName")
+	}
+
+	conds, err := parsePreconditions(r.URL.Query())
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	dstBucket := r.PathValue("destinationBucket")
+
+	acl, err := s.parsePredefinedObjectACL(dstBucket, "destinationPredefinedAcl", r.URL.Query().Get("destinationPredefinedAcl"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	srcKey, dstKey, err := parseCopyKeys(r.Header)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	src, err := s.statCopySource(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	info, err := s.copyObject(r.Context(), r.PathValue("bucket"), src, dstBucket, r.PathValue("destinationObject"), &override, conds, srcKey, dstKey)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	s.initObjectACL(dstBucket, info, acl)
+
+	writeJSON(w, http.StatusOK, s.renderObject(r, dstBucket, info))
+}
+
+// Progress of a rewrite spanning several calls. The token pins the source
+// generation, so that every call works on the same data.
+type rewriteProgress struct {
+	Generation int64
+	Rewritten  int64
+}
+
+func encodeRewriteToken(progress rewriteProgress) string {
+	return base64.RawURLEncoding.EncodeToString(fmt.Appendf(nil, "%d:%d", progress.Generation, progress.Rewritten))
+}
+
+func decodeRewriteToken(token string) (rewriteProgress, error) {
+	decoded, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return rewriteProgress{}, errBadRequest("Invalid rewriteToken: %s", token)
+	}
+
+	generation, rewritten, found := strings.Cut(string(decoded), ":")
+	if !found {
+		return rewriteProgress{}, errBadRequest("Invalid rewriteToken: %s", token)
+	}
+
+	var progress rewriteProgress
+	if progress.Generation, err = strconv.ParseInt(generation, 10, 64); err != nil {
+		return rewriteProgress{}, errBadRequest("Invalid rewriteToken: %s", token)
+	}
+	if progress.Rewritten, err = strconv.ParseInt(rewritten, 10, 64); err != nil {
+		return rewriteProgress{}, errBadRequest("Invalid rewriteToken: %s", token)
+	}
+
+	return progress, nil
+}
+
+// Handles rewriteTo. MinIO copies server-side in a single call, so the data is
+// copied once the last chunk is reached and earlier calls only report progress.
+func (s *StorageService) handleRewriteObject(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	var override Object
+	if err := readJSON(r, &override); err != nil {
+		writeError(w, err)
+		return
+	}
+	if override.KmsKeyName == "" {
+		override.KmsKeyName = r.URL.Query().Get("destinationKmsKeyName")
+	}
+
+	conds, err := parsePreconditions(query)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	dstBucket := r.PathValue("destinationBucket")
+
+	acl, err := s.parsePredefinedObjectACL(dstBucket, "destinationPredefinedAcl", query.Get("destinationPredefinedAcl"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	maxBytes := int64(0)
+	if value := query.Get("maxBytesRewrittenPerCall"); value != "" {
+		maxBytes, err = strconv.ParseInt(value, 10, 64)
+		if err != nil || maxBytes < 0 || maxBytes%rewriteChunkSize != 0 {
+			writeError(w, errBadRequest("maxBytesRewrittenPerCall must be a multiple of %d", rewriteChunkSize))
+			return
+		}
+	}
+
+	var progress rewriteProgress
+	if token := query.Get("rewriteToken"); token != "" {
+		progress, err = decodeRewriteToken(token)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		query.Set("sourceGeneration", strconv.FormatInt(progress.Generation, 10))
+		r.URL.RawQuery = query.Encode()
+	}
+
+	srcKey, dstKey, err := parseCopyKeys(r.Header)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	src, err := s.statCopySource(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	progress.Generation = parseObjectAttrs(src).Generation
+
+	if maxBytes > 0 && progress.Rewritten+maxBytes < src.Size {
+		progress.Rewritten += maxBytes
+
+		writeJSON(w, http.StatusOK, &RewriteResponse{
+			Kind:                "storage#rewriteResponse",
+			TotalBytesRewritten: progress.Rewritten,
+			ObjectSize:          src.Size,
+			RewriteToken:        encodeRewriteToken(progress),
+		})
+		return
+	}
+
+	info, err := s.copyObject(r.Context(), r.PathValue("bucket"), src, dstBucket, r.PathValue("destinationObject"), &override, conds, srcKey, dstKey)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	s.initObjectACL(dstBucket, info, acl)
+
+	writeJSON(w, http.StatusOK, &RewriteResponse{
+		Kind:                "storage#rewriteResponse",
+		TotalBytesRewritten: info.Size,
+		ObjectSize:          info.Size,
+		Done:                true,
+		Resource:            s.renderObject(r, dstBucket, info),
+	})
+}
@@ -0,0 +1,115 @@
+package storage
+
+import (
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Path prefixes of JSON API calls that address a bucket by name
+var jsonBucketPrefixes = []string{
+	"/storage/v1/b/",
+	"/upload/storage/v1/b/",
+	"/download/storage/v1/b/",
+}
+
+func validateCORS(rules []BucketCors) error {
+	for _, rule := range rules {
+		if rule.MaxAgeSeconds < 0 {
+			return errBadRequest("Invalid value for maxAgeSeconds: %d", rule.MaxAgeSeconds)
+		}
+	}
+
+	return nil
+}
+
+// Returns the bucket whose CORS rules apply to a request, or an empty
+// string for requests that don't address a bucket, such as bucket listings
+func corsBucket(r *http.Request, virtualBucket string) string {
+	if virtualBucket != "" {
+		return virtualBucket
+	}
+
+	for _, prefix := range jsonBucketPrefixes {
+		if rest, ok := strings.CutPrefix(r.URL.Path, prefix); ok {
+			bucket, _, _ := strings.Cut(rest, "/")
+			return bucket
+		}
+	}
+
+	if isJSONAPIPath(r.URL.Path) {
+		return ""
+	}
+
+	bucket, _, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+
+	return bucket
+}
+
+// Finds the first rule allowing origin to send requests with method
+func matchCORS(rules []BucketCors, origin, method string) *BucketCors {
+	for i, rule := range rules {
+		originAllowed := slices.ContainsFunc(rule.Origin, func(allowed string) bool {
+			return allowed == "*" || allowed == origin
+		})
+		methodAllowed := slices.ContainsFunc(rule.Method, func(allowed string) bool {
+			return allowed == "*" || strings.EqualFold(allowed, method)
+		})
+
+		if originAllowed && methodAllowed {
+			return &rules[i]
+		}
+	}
+
+	return nil
+}
+
+// Applies the bucket's CORS rules to a cross-origin request. Preflight
+// requests are answered here, in which case it returns true.
+func (s *StorageService) handleCORS(w http.ResponseWriter, r *http.Request, bucket string) bool {
+	origin := r.Header.Get("Origin")
+	preflight := r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != ""
+
+	method := r.Method
+	if preflight {
+		method = r.Header.Get("Access-Control-Request-Method")
+	}
+
+	var rule *BucketCors
+	if bucket != "" {
+		rule = matchCORS(s.buckets.Get(bucket, s.defaultBucketAttrs(time.Time{})).CORS, origin, method)
+	}
+
+	header := w.Header()
+	header.Add("Vary", "Origin")
+
+	if rule != nil {
+		header.Set("Access-Control-Allow-Origin", origin)
+	}
+
+	if !preflight {
+		if rule != nil && len(rule.ResponseHeader) > 0 {
+			header.Set("Access-Control-Expose-Headers", strings.Join(rule.ResponseHeader, ", "))
+		}
+		return false
+	}
+
+	if rule == nil {
+		w.WriteHeader(http.StatusForbidden)
+		return true
+	}
+
+	header.Set("Access-Control-Allow-Methods", strings.Join(rule.Method, ", "))
+	if len(rule.ResponseHeader) > 0 {
+		header.Set("Access-Control-Allow-Headers", strings.Join(rule.ResponseHeader, ", "))
+	}
+	if rule.MaxAgeSeconds > 0 {
+		header.Set("Access-Control-Max-Age", strconv.FormatInt(rule.MaxAgeSeconds, 10))
+	}
+
+	w.WriteHeader(http.StatusOK)
+
+	return true
+}
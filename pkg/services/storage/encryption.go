@@ -0,0 +1,234 @@
+package storage
+
+import (
+	"context"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// Header prefixes carrying the customer-supplied key of the object a request
+// addresses, and of the source object of a copy
+const (
+	encryptionHeaderPrefix           = "X-Goog-Encryption-"
+	copySourceEncryptionHeaderPrefix = "X-Goog-Copy-Source-Encryption-"
+)
+
+// The only algorithm GCS accepts customer-supplied keys for
+const encryptionAlgorithm = "AES256"
+
+// A customer-supplied AES-256 key. GCS never stores these, so only the
+// SHA256 is kept with the object to check the key of later reads.
+type customerKey struct {
+	key    []byte
+	sha256 string
+}
+
+func errCustomerKey(reason, format string, args ...any) *apiError {
+	return newAPIError(http.StatusBadRequest, reason, format, args...)
+}
+
+// Reads the customer-supplied key under the given header prefix, nil when there is none
+func parseCustomerKey(header http.Header, prefix string) (*customerKey, error) {
+	algorithm := header.Get(prefix + "Algorithm")
+	encoded := header.Get(prefix + "Key")
+	digest := header.Get(prefix + "Key-Sha256")
+
+	if algorithm == "" && encoded == "" && digest == "" {
+		return nil, nil
+	}
+
+	name := strings.ToLower(prefix)
+
+	if algorithm != encryptionAlgorithm {
+		return nil, errCustomerKey("customerEncryptionAlgorithmIsInvalid",
+			"Missing or invalid %salgorithm header, only %s is supported.", name, encryptionAlgorithm)
+	}
+
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(key) != 32 {
+		return nil, errCustomerKey("customerEncryptionKeyFormatIsInvalid",
+			"Missing or invalid %skey header, expected a base64 encoded 256-bit key.", name)
+	}
+
+	sum := sha256.Sum256(key)
+	if digest != base64.StdEncoding.EncodeToString(sum[:]) {
+		return nil, errCustomerKey("customerEncryptionKeySha256IsInvalid",
+			"The %skey-sha256 header doesn't match the SHA256 hash of the key.", name)
+	}
+
+	return &customerKey{key: key, sha256: digest}, nil
+}
+
+// Reads the customer-supplied keys of a copy's source and destination
+func parseCopyKeys(header http.Header) (*customerKey, *customerKey, error) {
+	srcKey, err := parseCustomerKey(header, copySourceEncryptionHeaderPrefix)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	dstKey, err := parseCustomerKey(header, encryptionHeaderPrefix)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	return srcKey, dstKey, nil
+}
+
+// Returns the key's SHA256, empty for a missing key
+func (k *customerKey) digest() string {
+	if k == nil {
+		return ""
+	}
+
+	return k.sha256
+}
+
+// Returns an AES-CTR key stream positioned at the given offset of the data
+func (k *customerKey) stream(iv []byte, offset int64) cipher.Stream {
+	// Keys are checked to be 256 bits long when parsed
+	block, _ := aes.NewCipher(k.key)
+
+	// Advance the counter to the block holding offset, wrapping around like cipher.NewCTR does
+	counter := make([]byte, aes.BlockSize)
+	copy(counter, iv)
+
+	blocks := uint64(offset / aes.BlockSize)
+	for i := aes.BlockSize - 1; i >= 0 && blocks > 0; i-- {
+		sum := uint64(counter[i]) + blocks&0xff
+		counter[i] = byte(sum)
+		blocks = blocks>>8 + sum>>8
+	}
+
+	stream := cipher.NewCTR(block, counter)
+
+	skip := make([]byte, offset%aes.BlockSize)
+	stream.XORKeyStream(skip, skip)
+
+	return stream
+}
+
+// Checks that a read supplies the key the object was written with, and only then
+func checkCustomerKey(attrs objectAttrs, key *customerKey) error {
+	switch {
+	case attrs.KeySHA256 == "" && key != nil:
+		return errCustomerKey("resourceNotEncryptedWithCustomerEncryptionKey",
+			"The target object is not encrypted by a customer-supplied encryption key.")
+	case attrs.KeySHA256 != "" && key == nil:
+		return errCustomerKey("resourceIsEncryptedWithCustomerEncryptionKey",
+			"The target object is encrypted by a customer-supplied encryption key.")
+	case attrs.KeySHA256 != key.digest():
+		return errCustomerKey("customerEncryptionKeyIsIncorrect",
+			"The provided encryption key is incorrect.")
+	}
+
+	return nil
+}
+
+// Records the Cloud KMS key of a new object, falling back to the bucket's default for
+// objects without a customer-supplied key. Only the key name is kept, the data isn't encrypted with it.
+func (s *StorageService) resolveKMSKey(bucket string, attrs *objectAttrs, key *customerKey) error {
+	if key == nil {
+		if attrs.KMSKeyName == "" {
+			attrs.KMSKeyName = s.buckets.Get(bucket, s.defaultBucketAttrs(time.Time{})).DefaultKMSKeyName
+		}
+		return nil
+	}
+
+	if attrs.KMSKeyName != "" {
+		return errBadRequest("Cannot use both a customer-supplied encryption key and a Cloud KMS key for the same object.")
+	}
+
+	return nil
+}
+
+// Prepares the encryption of a new object's data. Data written with a customer-supplied key is
+// encrypted with AES-256 in CTR mode under a fresh IV, which keeps its size and lets ranged reads
+// decrypt from any offset. Returns a nil stream for data stored in the clear.
+func (s *StorageService) setupEncryption(bucket string, attrs *objectAttrs, key *customerKey) (cipher.Stream, error) {
+	if err := s.resolveKMSKey(bucket, attrs, key); err != nil {
+		return nil, err
+	}
+
+	attrs.KeySHA256 = ""
+	attrs.EncryptionIV = nil
+
+	if key == nil {
+		return nil, nil
+	}
+
+	iv := make([]byte, aes.BlockSize)
+	if _, err := rand.Read(iv); err != nil {
+		return nil, fmt.Errorf("failed to generate encryption IV: %w", err)
+	}
+
+	attrs.KeySHA256 = key.sha256
+	attrs.EncryptionIV = iv
+
+	return key.stream(iv, 0), nil
+}
+
+// Decrypts an object's data as it is read, from whichever offset it was seeked to
+type decryptingReader struct {
+	object *minio.Object
+	key    *customerKey
+	iv     []byte
+	offset int64
+	stream cipher.Stream
+}
+
+func (d *decryptingReader) Read(p []byte) (int, error) {
+	if d.stream == nil {
+		d.stream = d.key.stream(d.iv, d.offset)
+	}
+
+	n, err := d.object.Read(p)
+	d.stream.XORKeyStream(p[:n], p[:n])
+	d.offset += int64(n)
+
+	return n, err
+}
+
+func (d *decryptingReader) Seek(offset int64, whence int) (int64, error) {
+	pos, err := d.object.Seek(offset, whence)
+	if err != nil {
+		return pos, err
+	}
+
+	d.offset = pos
+	d.stream = nil
+
+	return pos, nil
+}
+
+func (d *decryptingReader) Close() error {
+	return d.object.Close()
+}
+
+// Opens an object version for reading, decrypting it with the customer-supplied key it was written with
+func (s *StorageService) openObject(ctx context.Context, bucket string, info minio.ObjectInfo, key *customerKey) (io.ReadSeekCloser, error) {
+	attrs := parseObjectAttrs(info)
+	if err := checkCustomerKey(attrs, key); err != nil {
+		return nil, err
+	}
+
+	object, err := s.client.GetObject(ctx, bucket, info.Key, minio.GetObjectOptions{VersionID: info.VersionID})
+	if err != nil {
+		return nil, err
+	}
+
+	if key == nil {
+		return object, nil
+	}
+
+	return &decryptingReader{object: object, key: key, iv: attrs.EncryptionIV}, nil
+}
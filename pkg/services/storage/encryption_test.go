@@ -0,0 +1,40 @@
+package storage
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"testing"
+)
+
+func TestCustomerKeyStream(t *testing.T) {
+	key := &customerKey{key: bytes.Repeat([]byte{0x42}, 32)}
+	block, _ := aes.NewCipher(key.key)
+
+	plaintext := make([]byte, 300*aes.BlockSize+7)
+	for i := range plaintext {
+		plaintext[i] = byte(i * 7)
+	}
+
+	ivs := map[string][]byte{
+		"zero":          make([]byte, aes.BlockSize),
+		"all ones":      bytes.Repeat([]byte{0xff}, aes.BlockSize),
+		"low byte 0x01": append(make([]byte, aes.BlockSize-1), 0x01),
+	}
+
+	offsets := []int64{0, 15, 16, 17, 255 * aes.BlockSize, 256*aes.BlockSize - 1, 256 * aes.BlockSize, 256*aes.BlockSize + 3, 299*aes.BlockSize + 9}
+
+	for name, iv := range ivs {
+		ciphertext := make([]byte, len(plaintext))
+		cipher.NewCTR(block, iv).XORKeyStream(ciphertext, plaintext)
+
+		for _, offset := range offsets {
+			got := make([]byte, len(ciphertext)-int(offset))
+			key.stream(iv, offset).XORKeyStream(got, ciphertext[offset:])
+
+			if !bytes.Equal(got, plaintext[offset:]) {
+				t.Errorf("%s IV: decrypting from offset %d doesn't match the plaintext", name, offset)
+			}
+		}
+	}
+}
@@ -0,0 +1,245 @@
+package storage
+
+import (
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"net/http"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// A GCS API error, rendered as `{"error": {...}}` on the JSON API
+// and as `<Error>...</Error>` on the XML API
+type apiError struct {
+	Code    int
+	Reason  string
+	Message string
+
+	// XML API error code, derived from Reason when empty
+	XMLCode string
+	// Extra explanation only the XML API carries
+	Details string
+}
+
+func (e *apiError) Error() string {
+	return fmt.Sprintf("%d %s: %s", e.Code, e.Reason, e.Message)
+}
+
+func newAPIError(code int, reason string, format string, args ...any) *apiError {
+	return &apiError{
+		Code:    code,
+		Reason:  reason,
+		Message: fmt.Sprintf(format, args...),
+	}
+}
+
+func errBadRequest(format string, args ...any) *apiError {
+	return newAPIError(http.StatusBadRequest, "invalid", format, args...)
+}
+
+func errNotFound(format string, args ...any) *apiError {
+	return newAPIError(http.StatusNotFound, "notFound", format, args...)
+}
+
+func errConflict(format string, args ...any) *apiError {
+	return newAPIError(http.StatusConflict, "conflict", format, args...)
+}
+
+func errPreconditionFailed() *apiError {
+	return newAPIError(http.StatusPreconditionFailed, "conditionNotMet",
+		"At least one of the pre-conditions you specified did not hold.")
+}
+
+type errorItem struct {
+	Domain  string `json:"domain"`
+	Reason  string `json:"reason"`
+	Message string `json:"message"`
+}
+
+type errorBody struct {
+	Error struct {
+		Code    int         `json:"code"`
+		Message string      `json:"message"`
+		Errors  []errorItem `json:"errors"`
+	} `json:"error"`
+}
+
+func newErrorBody(e *apiError) errorBody {
+	var body errorBody
+	body.Error.Code = e.Code
+	body.Error.Message = e.Message
+	body.Error.Errors = []errorItem{{
+		Domain:  "global",
+		Reason:  e.Reason,
+		Message: e.Message,
+	}}
+
+	return body
+}
+
+// How an S3 error code surfaces in the GCS JSON API
+type s3ErrorMapping struct {
+	code    int
+	reason  string
+	message func(resp minio.ErrorResponse) string
+}
+
+var s3Errors = map[string]s3ErrorMapping{
+	"NoSuchBucket": {http.StatusNotFound, "notFound", func(minio.ErrorResponse) string {
+		return "The specified bucket does not exist."
+	}},
+	"NoSuchKey": {http.StatusNotFound, "notFound", func(resp minio.ErrorResponse) string {
+		return fmt.Sprintf("No such object: %s/%s", resp.BucketName, resp.Key)
+	}},
+	"NoSuchVersion": {http.StatusNotFound, "notFound", func(resp minio.ErrorResponse) string {
+		return fmt.Sprintf("No such object: %s/%s", resp.BucketName, resp.Key)
+	}},
+	"NoSuchUpload": {http.StatusNotFound, "notFound", func(minio.ErrorResponse) string {
+		return "No such upload session."
+	}},
+	"BucketAlreadyOwnedByYou": {http.StatusConflict, "conflict", func(minio.ErrorResponse) string {
+		return "Your previous request to create the named bucket succeeded and you already own it."
+	}},
+	"BucketAlreadyExists": {http.StatusConflict, "conflict", func(minio.ErrorResponse) string {
+		return "Your previous request to create the named bucket succeeded and you already own it."
+	}},
+	"BucketNotEmpty": {http.StatusConflict, "conflict", func(minio.ErrorResponse) string {
+		return "The bucket you tried to delete is not empty."
+	}},
+	"InvalidBucketName": {http.StatusBadRequest, "invalid", func(resp minio.ErrorResponse) string {
+		return fmt.Sprintf("Invalid bucket name: '%s'", resp.BucketName)
+	}},
+	"InvalidArgument":       {http.StatusBadRequest, "invalid", nil},
+	"InvalidRequest":        {http.StatusBadRequest, "invalid", nil},
+	"EntityTooLarge":        {http.StatusBadRequest, "invalid", nil},
+	"EntityTooSmall":        {http.StatusBadRequest, "invalid", nil},
+	"InvalidPart":           {http.StatusBadRequest, "invalid", nil},
+	"AccessDenied":          {http.StatusForbidden, "forbidden", nil},
+	"SignatureDoesNotMatch": {http.StatusForbidden, "forbidden", nil},
+	"InvalidAccessKeyId":    {http.StatusForbidden, "forbidden", nil},
+	"PreconditionFailed": {http.StatusPreconditionFailed, "conditionNotMet", func(minio.ErrorResponse) string {
+		return "At least one of the pre-conditions you specified did not hold."
+	}},
+	"InvalidRange": {http.StatusRequestedRangeNotSatisfiable, "requestedRangeNotSatisfiable", func(minio.ErrorResponse) string {
+		return "The requested range cannot be satisfied."
+	}},
+	"MethodNotAllowed": {http.StatusMethodNotAllowed, "methodNotAllowed", nil},
+	"SlowDown":         {http.StatusTooManyRequests, "rateLimitExceeded", nil},
+}
+
+// Maps an S3 error response from MinIO onto a GCS API error, falling back
+// to the HTTP status when the code is unknown
+func fromS3Error(resp minio.ErrorResponse) *apiError {
+	mapping, ok := s3Errors[resp.Code]
+	if !ok {
+		mapping = s3ErrorMapping{code: resp.StatusCode, reason: "invalid"}
+		if resp.StatusCode == 0 || resp.StatusCode >= http.StatusInternalServerError {
+			mapping = s3ErrorMapping{code: http.StatusInternalServerError, reason: "backendError"}
+		}
+	}
+
+	message := resp.Message
+	if mapping.message != nil {
+		message = mapping.message(resp)
+	}
+	if message == "" {
+		message = http.StatusText(mapping.code)
+	}
+
+	apiErr := newAPIError(mapping.code, mapping.reason, "%s", message)
+	// The XML API reports S3 error codes as they are
+	apiErr.XMLCode = resp.Code
+
+	return apiErr
+}
+
+// Converts any error returned by a handler or by MinIO into a GCS API error
+func toAPIError(err error) *apiError {
+	var apiErr *apiError
+	if errors.As(err, &apiErr) {
+		return apiErr
+	}
+
+	resp := minio.ToErrorResponse(err)
+	if resp.Code == "" {
+		return newAPIError(http.StatusInternalServerError, "backendError", "%s", err.Error())
+	}
+
+	return fromS3Error(resp)
+}
+
+func writeError(w http.ResponseWriter, err error) {
+	apiErr := toAPIError(err)
+
+	if _, ok := w.(*xmlResponseWriter); ok {
+		writeXMLError(w, apiErr)
+		return
+	}
+
+	writeJSON(w, apiErr.Code, newErrorBody(apiErr))
+}
+
+var xmlErrorCodes = map[string]string{
+	"notFound":              "NoSuchKey",
+	"conditionNotMet":       "PreconditionFailed",
+	"invalid":               "InvalidArgument",
+	"forbidden":             "AccessDenied",
+	"required":              "AccessDenied",
+	"retentionPolicyNotMet": "AccessDenied",
+	"conflict":              "Conflict",
+	"backendError":          "InternalError",
+
+	"customerEncryptionAlgorithmIsInvalid":          "InvalidArgument",
+	"customerEncryptionKeyFormatIsInvalid":          "InvalidArgument",
+	"customerEncryptionKeySha256IsInvalid":          "InvalidArgument",
+	"customerEncryptionKeyIsIncorrect":              "InvalidArgument",
+	"resourceIsEncryptedWithCustomerEncryptionKey":  "ResourceIsEncryptedWithCustomerEncryptionKey",
+	"resourceNotEncryptedWithCustomerEncryptionKey": "ResourceNotEncryptedWithCustomerEncryptionKey",
+}
+
+type xmlErrorBody struct {
+	XMLName xml.Name `xml:"Error"`
+	Code    string   `xml:"Code"`
+	Message string   `xml:"Message"`
+	Details string   `xml:"Details,omitempty"`
+}
+
+// Returns the code an error is reported under on the XML API
+func xmlErrorCode(apiErr *apiError) string {
+	code := apiErr.XMLCode
+	if code == "" {
+		code = xmlErrorCodes[apiErr.Reason]
+	}
+	if code == "" {
+		code = "InternalError"
+	}
+
+	return code
+}
+
+// Encodes an error as an XML API error document, including the XML declaration
+func marshalXMLError(apiErr *apiError) ([]byte, error) {
+	body, err := xml.Marshal(xmlErrorBody{
+		Code:    xmlErrorCode(apiErr),
+		Message: apiErr.Message,
+		Details: apiErr.Details,
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return append([]byte(xml.Header), body...), nil
+}
+
+func writeXMLError(w http.ResponseWriter, apiErr *apiError) {
+	body, err := marshalXMLError(apiErr)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/xml; charset=UTF-8")
+	w.WriteHeader(apiErr.Code)
+	w.Write(body)
+}
@@ -0,0 +1,86 @@
+package storage
+
+import (
+	"regexp"
+	"strings"
+)
+
+// Compiles a GCS `matchGlob` pattern into a regexp.
+//
+// `*` matches within a path segment, `**` across segments,
+// `?` a single non-separator character, and `[...]` / `{a,b}`
+// character classes and alternatives.
+func compileGlob(pattern string) (*regexp.Regexp, error) {
+	var sb strings.Builder
+	sb.WriteString("^")
+
+	inGroup := false
+	for i := 0; i < len(pattern); i++ {
+		ch := pattern[i]
+
+		switch ch {
+		case '*':
+			if i+1 < len(pattern) && pattern[i+1] == '*' {
+				sb.WriteString(".*")
+				i++
+			} else {
+				sb.WriteString("[^/]*")
+			}
+		case '?':
+			sb.WriteString("[^/]")
+		case '[':
+			end := strings.IndexByte(pattern[i+1:], ']')
+			if end < 0 {
+				return nil, errBadRequest("Invalid matchGlob: unterminated character class in %q", pattern)
+			}
+
+			class := pattern[i+1 : i+1+end]
+			if strings.HasPrefix(class, "!") {
+				class = "^" + class[1:]
+			}
+
+			sb.WriteString("[" + class + "]")
+			i += end + 1
+		case '{':
+			if inGroup {
+				return nil, errBadRequest("Invalid matchGlob: nested braces in %q", pattern)
+			}
+
+			inGroup = true
+			sb.WriteString("(?:")
+		case '}':
+			if !inGroup {
+				return nil, errBadRequest("Invalid matchGlob: unbalanced braces in %q", pattern)
+			}
+
+			inGroup = false
+			sb.WriteString(")")
+		case ',':
+			if inGroup {
+				sb.WriteString("|")
+			} else {
+				sb.WriteString(",")
+			}
+		case '\\':
+			if i+1 < len(pattern) {
+				i++
+				sb.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+			}
+		default:
+			sb.WriteString(regexp.QuoteMeta(pattern[i : i+1]))
+		}
+	}
+
+	if inGroup {
+		return nil, errBadRequest("Invalid matchGlob: unbalanced braces in %q", pattern)
+	}
+
+	sb.WriteString("$")
+
+	re, err := regexp.Compile(sb.String())
+	if err != nil {
+		return nil, errBadRequest("Invalid matchGlob %q: %v", pattern, err)
+	}
+
+	return re, nil
+}
@@ -0,0 +1,50 @@
+package storage
+
+import "testing"
+
+func TestCompileGlob(t *testing.T) {
+	tests := []struct {
+		pattern string
+		name    string
+		match   bool
+	}{
+		{"*.txt", "a.txt", true},
+		{"*.txt", "dir/a.txt", false},
+		{"**.txt", "dir/sub/a.txt", true},
+		{"dir/**", "dir/sub/a.txt", true},
+		{"dir/**", "other/a.txt", false},
+		{"dir/**/a.txt", "dir/sub/a.txt", true},
+		{"a?c", "abc", true},
+		{"a?c", "a/c", false},
+		{"a?c", "ac", false},
+		{"[ab].txt", "b.txt", true},
+		{"[ab].txt", "c.txt", false},
+		{"[!ab].txt", "c.txt", true},
+		{"[!ab].txt", "a.txt", false},
+		{"*.{jpg,png}", "photo.png", true},
+		{"*.{jpg,png}", "photo.gif", false},
+		{"a,b", "a,b", true},
+		{"a.b", "axb", false},
+		{`\*`, "*", true},
+		{`\*`, "a", false},
+	}
+
+	for _, tt := range tests {
+		re, err := compileGlob(tt.pattern)
+		if err != nil {
+			t.Fatalf("compileGlob(%q) failed: %v", tt.pattern, err)
+		}
+
+		if got := re.MatchString(tt.name); got != tt.match {
+			t.Errorf("compileGlob(%q) matching %q = %v, want %v", tt.pattern, tt.name, got, tt.match)
+		}
+	}
+}
+
+func TestCompileGlobInvalid(t *testing.T) {
+	for _, pattern := range []string{"[abc", "{a,b", "a}", "{a,{b}}"} {
+		if _, err := compileGlob(pattern); err == nil {
+			t.Errorf("compileGlob(%q) succeeded, want an error", pattern)
+		}
+	}
+}
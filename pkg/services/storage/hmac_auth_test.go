@@ -0,0 +1,101 @@
+package storage
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/minio/minio-go/v7/pkg/signer"
+)
+
+func TestVerifyHMACAuthorization(t *testing.T) {
+	managed := newHMACKeyStore()
+	managed.Add(hmacKey{AccessID: "GOOG1EACTIVE", Secret: "active-secret", State: hmacKeyActive})
+	managed.Add(hmacKey{AccessID: "GOOG1EINACTIVE", Secret: "inactive-secret", State: hmacKeyInactive})
+
+	s := &StorageService{signingKeys: &signingKeys{
+		hmac:    map[string]string{"GOOGTEST": "secret"},
+		managed: managed,
+	}}
+
+	request := func(method, target string) *http.Request {
+		r := httptest.NewRequest(method, target, nil)
+		r.Header.Set("X-Amz-Meta-Note", "hello")
+		return r
+	}
+
+	// Signed the way S3 clients sign requests
+	v4 := func(r *http.Request, accessID, secret string) *http.Request {
+		return signer.SignV4(*r, accessID, secret, "", "us-east-1")
+	}
+	v2 := func(r *http.Request, accessID, secret string) *http.Request {
+		return signer.SignV2(*r, accessID, secret, false)
+	}
+
+	tests := []struct {
+		name   string
+		req    *http.Request
+		tamper func(r *http.Request)
+		bucket string
+		code   string
+	}{
+		{"unsigned", request("GET", "http://localhost:9999/bucket/object"), nil, "", ""},
+		{"bearer token", func() *http.Request {
+			r := request("GET", "http://localhost:9999/bucket/object")
+			r.Header.Set("Authorization", "Bearer token")
+			return r
+		}(), nil, "", ""},
+		{"V4 configured key", v4(request("GET", "http://localhost:9999/bucket/dir/my%20object?versionId=3"), "GOOGTEST", "secret"), nil, "", ""},
+		{"V4 managed key", v4(request("PUT", "http://localhost:9999/bucket/object?uploads"), "GOOG1EACTIVE", "active-secret"), nil, "", ""},
+		{"V4 virtual-hosted", v4(request("GET", "http://bucket.storage.googleapis.com/object"), "GOOGTEST", "secret"), nil, "bucket", ""},
+		{"V4 wrong secret", v4(request("GET", "http://localhost:9999/bucket/object"), "GOOGTEST", "other"), nil, "", "SignatureDoesNotMatch"},
+		{"V4 unknown key", v4(request("GET", "http://localhost:9999/bucket/object"), "GOOGOTHER", "secret"), nil, "", "InvalidAccessKeyId"},
+		{"V4 inactive key", v4(request("GET", "http://localhost:9999/bucket/object"), "GOOG1EINACTIVE", "inactive-secret"), nil, "", "InvalidAccessKeyId"},
+		{"V4 tampered path", v4(request("GET", "http://localhost:9999/bucket/object"), "GOOGTEST", "secret"), func(r *http.Request) {
+			r.URL.Path = "/bucket/other"
+		}, "", "SignatureDoesNotMatch"},
+		{"V4 tampered header", v4(request("GET", "http://localhost:9999/bucket/object"), "GOOGTEST", "secret"), func(r *http.Request) {
+			r.Header.Set("X-Amz-Meta-Note", "goodbye")
+		}, "", "SignatureDoesNotMatch"},
+		{"V4 without date", v4(request("GET", "http://localhost:9999/bucket/object"), "GOOGTEST", "secret"), func(r *http.Request) {
+			r.Header.Del("X-Amz-Date")
+		}, "", "AuthenticationRequired"},
+		{"V4 date outside the scope", v4(request("GET", "http://localhost:9999/bucket/object"), "GOOGTEST", "secret"), func(r *http.Request) {
+			r.Header.Set("X-Amz-Date", "20000101T000000Z")
+		}, "", "AuthenticationRequired"},
+		{"V4 replayed", v4(request("GET", "http://localhost:9999/bucket/object"), "GOOGTEST", "secret"), func(r *http.Request) {
+			// Dated an hour back, with a scope to match
+			signedAt := time.Now().UTC().Add(-time.Hour)
+			scope := strings.Split(strings.Split(r.Header.Get("Authorization"), "/")[1], "/")[0]
+			r.Header.Set("Authorization", strings.Replace(r.Header.Get("Authorization"), scope, signedAt.Format("20060102"), 1))
+			r.Header.Set("X-Amz-Date", signedAt.Format(signedURLDateFormat))
+		}, "", "RequestTimeTooSkewed"},
+		{"V4 malformed", request("GET", "http://localhost:9999/bucket/object"), func(r *http.Request) {
+			r.Header.Set("Authorization", "AWS4-HMAC-SHA256 Credential=GOOGTEST")
+		}, "", "AuthenticationRequired"},
+		{"V2 configured key", v2(request("GET", "http://localhost:9999/bucket/object?acl"), "GOOGTEST", "secret"), nil, "", ""},
+		{"V2 managed key", v2(request("PUT", "http://localhost:9999/bucket/object"), "GOOG1EACTIVE", "active-secret"), nil, "", ""},
+		{"V2 virtual-hosted", signer.SignV2(*request("GET", "http://bucket.storage.googleapis.com/object"), "GOOGTEST", "secret", true), nil, "bucket", ""},
+		{"V2 wrong secret", v2(request("GET", "http://localhost:9999/bucket/object"), "GOOGTEST", "other"), nil, "", "SignatureDoesNotMatch"},
+		{"V2 unknown key", v2(request("GET", "http://localhost:9999/bucket/object"), "GOOGOTHER", "secret"), nil, "", "InvalidAccessKeyId"},
+		{"V2 tampered sub-resource", v2(request("GET", "http://localhost:9999/bucket/object?acl"), "GOOGTEST", "secret"), func(r *http.Request) {
+			r.URL.RawQuery = "cors"
+		}, "", "SignatureDoesNotMatch"},
+	}
+
+	for _, tt := range tests {
+		if tt.tamper != nil {
+			tt.tamper(tt.req)
+		}
+
+		err := s.verifyHMACAuthorization(tt.req, tt.bucket)
+		switch {
+		case tt.code == "" && err != nil:
+			t.Errorf("%s: verifyHMACAuthorization() = %v, want success", tt.name, err)
+		case tt.code != "" && (err == nil || err.(*apiError).XMLCode != tt.code):
+			t.Errorf("%s: verifyHMACAuthorization() = %v, want %s", tt.name, err, tt.code)
+		}
+	}
+}
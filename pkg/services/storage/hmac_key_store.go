@@ -0,0 +1,117 @@
+package storage
+
+import (
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// States of an HMAC key. Only active keys authenticate requests, and only
+// inactive keys can be deleted.
+const (
+	hmacKeyActive   = "ACTIVE"
+	hmacKeyInactive = "INACTIVE"
+	hmacKeyDeleted  = "DELETED"
+)
+
+type hmacKey struct {
+	AccessID            string
+	Secret              string
+	Project             string
+	ServiceAccountEmail string
+	State               string
+	Created             time.Time
+	Updated             time.Time
+	Metageneration      int64
+}
+
+// Keeps HMAC keys for the lifetime of the MinIO container, which holds the users they are provisioned as.
+// Deleted keys are kept too, since GCS still lists them when asked to.
+type hmacKeyStore struct {
+	keys map[string]hmacKey
+	mu   sync.RWMutex
+}
+
+func newHMACKeyStore() *hmacKeyStore {
+	return &hmacKeyStore{
+		keys: make(map[string]hmacKey),
+	}
+}
+
+func (hs *hmacKeyStore) Add(key hmacKey) {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+
+	hs.keys[key.AccessID] = key
+}
+
+func (hs *hmacKeyStore) Get(accessID string) (hmacKey, bool) {
+	hs.mu.RLock()
+	defer hs.mu.RUnlock()
+
+	key, ok := hs.keys[accessID]
+	return key, ok
+}
+
+// Returns every key ordered by access ID
+func (hs *hmacKeyStore) List() []hmacKey {
+	hs.mu.RLock()
+	defer hs.mu.RUnlock()
+
+	keys := make([]hmacKey, 0, len(hs.keys))
+	for _, key := range hs.keys {
+		keys = append(keys, key)
+	}
+
+	slices.SortFunc(keys, func(a, b hmacKey) int { return strings.Compare(a.AccessID, b.AccessID) })
+
+	return keys
+}
+
+// Applies fn to a key and bumps its metageneration, unless fn fails
+func (hs *hmacKeyStore) Update(accessID string, fn func(key *hmacKey) error) (hmacKey, error) {
+	hs.mu.Lock()
+	defer hs.mu.Unlock()
+
+	key, ok := hs.keys[accessID]
+	if !ok {
+		return hmacKey{}, errNotFound("Access ID not found: %s", accessID)
+	}
+
+	if err := fn(&key); err != nil {
+		return hmacKey{}, err
+	}
+
+	key.Metageneration++
+	key.Updated = time.Now()
+	hs.keys[accessID] = key
+
+	return key, nil
+}
+
+// Returns the secret of an active key
+func (hs *hmacKeyStore) Secret(accessID string) (string, bool) {
+	hs.mu.RLock()
+	defer hs.mu.RUnlock()
+
+	key, ok := hs.keys[accessID]
+	if !ok || key.State != hmacKeyActive {
+		return "", false
+	}
+
+	return key.Secret, true
+}
+
+// Returns the service account an active key belongs to
+func (hs *hmacKeyStore) ServiceAccount(accessID string) (string, bool) {
+	hs.mu.RLock()
+	defer hs.mu.RUnlock()
+
+	key, ok := hs.keys[accessID]
+	if !ok || key.State != hmacKeyActive {
+		return "", false
+	}
+
+	return key.ServiceAccountEmail, true
+}
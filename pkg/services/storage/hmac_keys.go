@@ -0,0 +1,394 @@
+package storage
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/minio/madmin-go/v3"
+	"go.uber.org/zap"
+)
+
+// Access IDs look like GCS ones, a fixed prefix followed by uppercase letters and digits
+const (
+	hmacAccessIDPrefix   = "GOOG1E"
+	hmacAccessIDLength   = 61
+	hmacAccessIDAlphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZ234567"
+	hmacSecretBytes      = 30
+)
+
+// S3 actions a service account's MinIO policy allows for each Cloud Storage permission,
+// on the bucket itself and on the objects in it
+var permissionActions = map[string]struct{ bucket, object []string }{
+	"storage.buckets.get": {
+		bucket: []string{"s3:GetBucketLocation", "s3:GetBucketVersioning", "s3:GetLifecycleConfiguration"},
+	},
+	"storage.buckets.update": {
+		bucket: []string{"s3:PutBucketVersioning", "s3:PutLifecycleConfiguration"},
+	},
+	"storage.buckets.delete": {bucket: []string{"s3:DeleteBucket"}},
+	"storage.objects.list": {
+		bucket: []string{"s3:ListBucket", "s3:ListBucketVersions", "s3:ListBucketMultipartUploads"},
+	},
+	"storage.objects.get": {object: []string{"s3:GetObject", "s3:GetObjectVersion"}},
+	"storage.objects.create": {
+		object: []string{"s3:PutObject", "s3:AbortMultipartUpload", "s3:ListMultipartUploadParts"},
+	},
+	"storage.objects.delete": {object: []string{"s3:DeleteObject", "s3:DeleteObjectVersion"}},
+}
+
+type minioPolicy struct {
+	Version   string                 `json:"Version"`
+	Statement []minioPolicyStatement `json:"Statement"`
+}
+
+type minioPolicyStatement struct {
+	Effect   string   `json:"Effect"`
+	Action   []string `json:"Action"`
+	Resource []string `json:"Resource"`
+}
+
+func generateHMACKey() (accessID, secret string, err error) {
+	random := make([]byte, hmacAccessIDLength-len(hmacAccessIDPrefix)+hmacSecretBytes)
+	if _, err := rand.Read(random); err != nil {
+		return "", "", fmt.Errorf("failed to generate HMAC key: %w", err)
+	}
+
+	id := []byte(hmacAccessIDPrefix)
+	for _, b := range random[:hmacAccessIDLength-len(hmacAccessIDPrefix)] {
+		id = append(id, hmacAccessIDAlphabet[int(b)%len(hmacAccessIDAlphabet)])
+	}
+
+	return string(id), base64.StdEncoding.EncodeToString(random[len(random)-hmacSecretBytes:]), nil
+}
+
+// Names the MinIO policy shared by a service account's keys
+func serviceAccountPolicyName(email string) string {
+	name := strings.Map(func(r rune) rune {
+		if r >= 'a' && r <= 'z' || r >= '0' && r <= '9' || r == '-' || r == '.' {
+			return r
+		}
+		return '-'
+	}, strings.ToLower(email))
+
+	return "glocal-sa-" + name
+}
+
+// Builds the MinIO policy of a service account. Without IAM enforcement service accounts can
+// do anything, as they can through glocal, otherwise the policy mirrors the bucket IAM policies.
+func (s *StorageService) serviceAccountPolicy(ctx context.Context, email string) ([]byte, error) {
+	policy := minioPolicy{Version: "2012-10-17"}
+
+	if !s.config.EnforceIAM {
+		policy.Statement = []minioPolicyStatement{{Effect: "Allow", Action: []string{"s3:*"}, Resource: []string{"arn:aws:s3:::*"}}}
+		return json.Marshal(policy)
+	}
+
+	buckets, err := s.client.ListBuckets(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	caller := memberForEmail(email)
+
+	// Creating and listing buckets are project-level permissions, which glocal doesn't check
+	policy.Statement = []minioPolicyStatement{{
+		Effect: "Allow", Action: []string{"s3:CreateBucket", "s3:ListAllMyBuckets"}, Resource: []string{"arn:aws:s3:::*"},
+	}}
+
+	for _, info := range buckets {
+		bindings := s.buckets.Get(info.Name, s.defaultBucketAttrs(info.CreationDate)).iamBindings()
+
+		var bucketActions, objectActions []string
+		for permission, actions := range permissionActions {
+			if policyAllows(bindings, caller, permission) {
+				bucketActions = append(bucketActions, actions.bucket...)
+				objectActions = append(objectActions, actions.object...)
+			}
+		}
+
+		if len(bucketActions) > 0 {
+			slices.Sort(bucketActions)
+			policy.Statement = append(policy.Statement, minioPolicyStatement{
+				Effect: "Allow", Action: bucketActions, Resource: []string{"arn:aws:s3:::" + info.Name},
+			})
+		}
+		if len(objectActions) > 0 {
+			slices.Sort(objectActions)
+			policy.Statement = append(policy.Statement, minioPolicyStatement{
+				Effect: "Allow", Action: objectActions, Resource: []string{"arn:aws:s3:::" + info.Name + "/*"},
+			})
+		}
+	}
+
+	return json.Marshal(policy)
+}
+
+// Creates or replaces a service account's MinIO policy
+func (s *StorageService) syncServiceAccountPolicy(ctx context.Context, email string) error {
+	policy, err := s.serviceAccountPolicy(ctx, email)
+	if err != nil {
+		return err
+	}
+
+	if err := s.admin.AddCannedPolicy(ctx, serviceAccountPolicyName(email), policy); err != nil {
+		return fmt.Errorf("failed to store MinIO policy for %s: %w", email, err)
+	}
+
+	return nil
+}
+
+// Brings the MinIO policies of every service account with HMAC keys in line with
+// the bucket IAM policies, after one of them changed or a bucket came or went
+func (s *StorageService) syncServiceAccountPolicies(ctx context.Context) {
+	if !s.config.EnforceIAM {
+		return
+	}
+
+	synced := make(map[string]bool)
+	for _, key := range s.hmacKeys.List() {
+		if key.State == hmacKeyDeleted || synced[key.ServiceAccountEmail] {
+			continue
+		}
+		synced[key.ServiceAccountEmail] = true
+
+		if err := s.syncServiceAccountPolicy(ctx, key.ServiceAccountEmail); err != nil {
+			s.logger.Warn("Failed to update service account policy", zap.String("serviceAccount", key.ServiceAccountEmail), zap.Error(err))
+		}
+	}
+}
+
+// Provisions a key as a MinIO user bound to its service account's policy
+func (s *StorageService) provisionHMACKey(ctx context.Context, key hmacKey) error {
+	if err := s.syncServiceAccountPolicy(ctx, key.ServiceAccountEmail); err != nil {
+		return err
+	}
+
+	if err := s.admin.AddUser(ctx, key.AccessID, key.Secret); err != nil {
+		return fmt.Errorf("failed to create MinIO user: %w", err)
+	}
+
+	_, err := s.admin.AttachPolicy(ctx, madmin.PolicyAssociationReq{
+		Policies: []string{serviceAccountPolicyName(key.ServiceAccountEmail)},
+		User:     key.AccessID,
+	})
+	if err != nil {
+		_ = s.admin.RemoveUser(ctx, key.AccessID)
+		return fmt.Errorf("failed to attach MinIO policy: %w", err)
+	}
+
+	return nil
+}
+
+func hmacKeySelfLink(r *http.Request, key hmacKey) string {
+	return baseURL(r) + "/storage/v1/projects/" + url.PathEscape(key.Project) + "/hmacKeys/" + url.PathEscape(key.AccessID)
+}
+
+func renderHMACKeyMetadata(r *http.Request, key hmacKey) *HmacKeyMetadata {
+	return &HmacKeyMetadata{
+		Kind:                "storage#hmacKeyMetadata",
+		ID:                  key.Project + "/" + key.AccessID,
+		SelfLink:            hmacKeySelfLink(r, key),
+		AccessID:            key.AccessID,
+		ProjectID:           key.Project,
+		ServiceAccountEmail: key.ServiceAccountEmail,
+		State:               key.State,
+		TimeCreated:         formatTime(key.Created),
+		Updated:             formatTime(key.Updated),
+		Etag:                metagenerationEtag(key.Metageneration),
+	}
+}
+
+// Looks up the key in the request path, which must belong to the project in it
+func (s *StorageService) lookupHMACKey(r *http.Request) (hmacKey, error) {
+	key, ok := s.hmacKeys.Get(r.PathValue("accessId"))
+	if !ok || key.Project != r.PathValue("project") {
+		return hmacKey{}, errNotFound("Access ID not found in project %s: %s", r.PathValue("project"), r.PathValue("accessId"))
+	}
+
+	return key, nil
+}
+
+func (s *StorageService) handleCreateHMACKey(w http.ResponseWriter, r *http.Request) {
+	email := r.URL.Query().Get("serviceAccountEmail")
+	if email == "" {
+		writeError(w, errBadRequest("Required parameter: serviceAccountEmail"))
+		return
+	}
+	if !strings.Contains(email, "@") {
+		writeError(w, errBadRequest("Invalid value for serviceAccountEmail: %s", email))
+		return
+	}
+
+	// Keys act as their service account, so callers may only mint them for their project's own
+	if s.config.EnforceIAM && !projectServiceAccount(memberForEmail(email), r.PathValue("project")) {
+		writeError(w, errBadRequest("Service account %s does not belong to project %s", email, r.PathValue("project")))
+		return
+	}
+
+	accessID, secret, err := generateHMACKey()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	now := time.Now()
+	key := hmacKey{
+		AccessID:            accessID,
+		Secret:              secret,
+		Project:             r.PathValue("project"),
+		ServiceAccountEmail: email,
+		State:               hmacKeyActive,
+		Created:             now,
+		Updated:             now,
+		Metageneration:      1,
+	}
+
+	if err := s.provisionHMACKey(r.Context(), key); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	s.hmacKeys.Add(key)
+
+	writeJSON(w, http.StatusOK, &HmacKey{
+		Kind:     "storage#hmacKey",
+		Metadata: renderHMACKeyMetadata(r, key),
+		Secret:   secret,
+	})
+}
+
+func (s *StorageService) handleListHMACKeys(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	email := query.Get("serviceAccountEmail")
+	showDeleted := query.Get("showDeletedKeys") == "true"
+
+	maxResults, err := parseMaxResults(query.Get("maxResults"), 1000)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	startAfter, err := decodePageToken(query.Get("pageToken"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	result := &HmacKeysMetadata{
+		Kind:  "storage#hmacKeysMetadata",
+		Items: []*HmacKeyMetadata{},
+	}
+
+	for _, key := range s.hmacKeys.List() {
+		if key.Project != r.PathValue("project") || key.AccessID <= startAfter ||
+			(email != "" && key.ServiceAccountEmail != email) || (key.State == hmacKeyDeleted && !showDeleted) {
+			continue
+		}
+
+		if len(result.Items) == maxResults {
+			result.NextPageToken = encodePageToken(result.Items[len(result.Items)-1].AccessID)
+			break
+		}
+
+		result.Items = append(result.Items, renderHMACKeyMetadata(r, key))
+	}
+
+	writeJSON(w, http.StatusOK, result)
+}
+
+func (s *StorageService) handleGetHMACKey(w http.ResponseWriter, r *http.Request) {
+	key, err := s.lookupHMACKey(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, renderHMACKeyMetadata(r, key))
+}
+
+// Switches a key between ACTIVE and INACTIVE, enabling or disabling its MinIO user to match
+func (s *StorageService) handleUpdateHMACKey(w http.ResponseWriter, r *http.Request) {
+	key, err := s.lookupHMACKey(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	var req HmacKeyMetadata
+	if err := readJSON(r, &req); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if req.State != hmacKeyActive && req.State != hmacKeyInactive {
+		writeError(w, errBadRequest("Invalid value for state: %s", req.State))
+		return
+	}
+
+	key, err = s.hmacKeys.Update(key.AccessID, func(key *hmacKey) error {
+		if req.Etag != "" && req.Etag != metagenerationEtag(key.Metageneration) {
+			return errPreconditionFailed()
+		}
+
+		if key.State == hmacKeyDeleted {
+			return errBadRequest("Cannot update keys in %s state.", hmacKeyDeleted)
+		}
+
+		status := madmin.AccountEnabled
+		if req.State == hmacKeyInactive {
+			status = madmin.AccountDisabled
+		}
+
+		if err := s.admin.SetUserStatus(r.Context(), key.AccessID, status); err != nil {
+			return fmt.Errorf("failed to update MinIO user: %w", err)
+		}
+
+		key.State = req.State
+
+		return nil
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, renderHMACKeyMetadata(r, key))
+}
+
+// Deletes an inactive key along with its MinIO user. The key stays listed as DELETED.
+func (s *StorageService) handleDeleteHMACKey(w http.ResponseWriter, r *http.Request) {
+	key, err := s.lookupHMACKey(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	_, err = s.hmacKeys.Update(key.AccessID, func(key *hmacKey) error {
+		if key.State != hmacKeyInactive {
+			return errBadRequest("Cannot delete keys in '%s' state.", key.State)
+		}
+
+		if err := s.admin.RemoveUser(r.Context(), key.AccessID); err != nil {
+			return fmt.Errorf("failed to remove MinIO user: %w", err)
+		}
+
+		key.State = hmacKeyDeleted
+		key.Secret = ""
+
+		return nil
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
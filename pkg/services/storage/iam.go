@@ -0,0 +1,583 @@
+package storage
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Header naming the caller a request acts as, either as a member such as
+// `serviceAccount:ci@project.iam.gserviceaccount.com` or as a plain email
+const impersonateHeader = "X-Goog-Impersonate"
+
+var (
+	bucketPermissions = []string{
+		"storage.buckets.get", "storage.buckets.update", "storage.buckets.delete",
+		"storage.buckets.getIamPolicy", "storage.buckets.setIamPolicy",
+	}
+	objectPermissions = []string{
+		"storage.objects.list", "storage.objects.get", "storage.objects.create",
+		"storage.objects.update", "storage.objects.delete",
+	}
+	// Needed to read and change object ACLs
+	objectACLPermissions = []string{"storage.objects.getIamPolicy", "storage.objects.setIamPolicy"}
+)
+
+// Permissions granted by the predefined and basic roles that cover Cloud Storage
+var rolePermissions = map[string][]string{
+	"roles/storage.admin":         slices.Concat(bucketPermissions, objectPermissions, objectACLPermissions),
+	"roles/storage.objectAdmin":   slices.Concat(objectPermissions, objectACLPermissions),
+	"roles/storage.objectUser":    objectPermissions,
+	"roles/storage.objectCreator": {"storage.objects.create"},
+	"roles/storage.objectViewer":  {"storage.objects.list", "storage.objects.get"},
+	"roles/storage.legacyBucketOwner": {
+		"storage.buckets.get", "storage.buckets.update",
+		"storage.buckets.getIamPolicy", "storage.buckets.setIamPolicy",
+		"storage.objects.list", "storage.objects.create", "storage.objects.delete",
+	},
+	"roles/storage.legacyBucketWriter": {
+		"storage.buckets.get", "storage.objects.list", "storage.objects.create", "storage.objects.delete",
+	},
+	"roles/storage.legacyBucketReader": {"storage.buckets.get", "storage.objects.list"},
+	"roles/storage.legacyObjectOwner":  slices.Concat([]string{"storage.objects.get", "storage.objects.update"}, objectACLPermissions),
+	"roles/storage.legacyObjectReader": {"storage.objects.get"},
+	"roles/owner":                      slices.Concat(bucketPermissions, objectPermissions, objectACLPermissions),
+	"roles/editor": slices.Concat(
+		[]string{"storage.buckets.get", "storage.buckets.update", "storage.buckets.delete"},
+		objectPermissions,
+	),
+	"roles/viewer": {"storage.buckets.get", "storage.objects.list", "storage.objects.get"},
+}
+
+// Returns the bindings of a bucket's policy, which grant the project's
+// owners, editors and viewers access until a policy is set. GCS grants them
+// object access through default object ACLs, which glocal doesn't evaluate,
+// so the default policy carries the equivalent legacy object roles.
+func (attrs bucketAttrs) iamBindings() []PolicyBinding {
+	if attrs.IAMBindings != nil {
+		return attrs.IAMBindings
+	}
+
+	if attrs.Project == "" {
+		return []PolicyBinding{}
+	}
+
+	return []PolicyBinding{
+		{
+			Role:    "roles/storage.legacyBucketOwner",
+			Members: []string{"projectEditor:" + attrs.Project, "projectOwner:" + attrs.Project},
+		},
+		{
+			Role:    "roles/storage.legacyBucketReader",
+			Members: []string{"projectViewer:" + attrs.Project},
+		},
+		{
+			Role:    "roles/storage.legacyObjectOwner",
+			Members: []string{"projectEditor:" + attrs.Project, "projectOwner:" + attrs.Project},
+		},
+		{
+			Role:    "roles/storage.legacyObjectReader",
+			Members: []string{"projectViewer:" + attrs.Project},
+		},
+	}
+}
+
+func (s *StorageService) renderPolicy(bucket string, attrs bucketAttrs) *Policy {
+	version := attrs.IAMVersion
+	if version == 0 {
+		version = 1
+	}
+
+	return &Policy{
+		Kind:       "storage#policy",
+		ResourceID: "projects/_/buckets/" + bucket,
+		Version:    version,
+		Etag:       metagenerationEtag(attrs.Metageneration),
+		Bindings:   attrs.iamBindings(),
+	}
+}
+
+// Names the caller of a request as an IAM member, or returns an empty
+// string for callers that don't identify themselves
+func (s *StorageService) callerIdentity(r *http.Request) string {
+	if principal := r.Header.Get(impersonateHeader); principal != "" {
+		if strings.Contains(principal, ":") {
+			return principal
+		}
+		return memberForEmail(principal)
+	}
+
+	// Signed URLs act as the service account that signed them, directly or through one of its HMAC keys
+	if credential := r.URL.Query().Get("X-Goog-Credential"); credential != "" {
+		accessID, _, _ := strings.Cut(credential, "/")
+		if strings.Contains(accessID, "@") {
+			return memberForEmail(accessID)
+		}
+		if email, ok := s.hmacKeys.ServiceAccount(accessID); ok {
+			return memberForEmail(email)
+		}
+	}
+
+	authorization := r.Header.Get("Authorization")
+
+	if accessID := hmacAccessID(authorization); accessID != "" {
+		if email, ok := s.hmacKeys.ServiceAccount(accessID); ok {
+			return memberForEmail(email)
+		}
+		return ""
+	}
+
+	token, ok := strings.CutPrefix(authorization, "Bearer ")
+	if !ok {
+		return ""
+	}
+
+	if email := tokenEmail(token); email != "" {
+		return memberForEmail(email)
+	}
+
+	return ""
+}
+
+// Reads the caller's email from a JWT bearer token without verifying it.
+// Opaque tokens are taken to be the caller's email when they look like one.
+func tokenEmail(token string) string {
+	parts := strings.Split(token, ".")
+	if len(parts) != 3 {
+		if strings.Contains(token, "@") {
+			return token
+		}
+		return ""
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(strings.TrimRight(parts[1], "="))
+	if err != nil {
+		return ""
+	}
+
+	var claims struct {
+		Email   string `json:"email"`
+		Subject string `json:"sub"`
+	}
+	if err := json.Unmarshal(payload, &claims); err != nil {
+		return ""
+	}
+
+	if claims.Email != "" {
+		return claims.Email
+	}
+	if strings.Contains(claims.Subject, "@") {
+		return claims.Subject
+	}
+
+	return ""
+}
+
+// Returns the HMAC access ID a request was signed with, through a signed URL or its Authorization header
+func requestAccessID(r *http.Request) string {
+	if credential := r.URL.Query().Get("X-Goog-Credential"); credential != "" {
+		accessID, _, _ := strings.Cut(credential, "/")
+		return accessID
+	}
+
+	return hmacAccessID(r.Header.Get("Authorization"))
+}
+
+// Reads the access ID from the Authorization header of an HMAC-signed XML API request,
+// signed either the V4 way (`GOOG4-HMAC-SHA256 Credential=<id>/...`, or its AWS
+// equivalent) or the V2 way (`GOOG1 <id>:<signature>`). The signature is checked
+// by verifyHMACAuthorization before the request is routed.
+func hmacAccessID(authorization string) string {
+	scheme, params, _ := strings.Cut(authorization, " ")
+
+	switch scheme {
+	case "GOOG4-HMAC-SHA256", "AWS4-HMAC-SHA256":
+		_, credential, found := strings.Cut(params, "Credential=")
+		if !found {
+			return ""
+		}
+		accessID, _, _ := strings.Cut(credential, "/")
+		return accessID
+	case "GOOG1", "AWS":
+		accessID, _, _ := strings.Cut(params, ":")
+		return accessID
+	}
+
+	return ""
+}
+
+func memberForEmail(email string) string {
+	if strings.HasSuffix(email, ".gserviceaccount.com") {
+		return "serviceAccount:" + email
+	}
+
+	return "user:" + email
+}
+
+// Reports whether a service account belongs to a project, judging by the
+// domains GCS hands out service account emails under. glocal counts these as
+// the project's owners, editors and viewers, and users as none of them.
+func projectServiceAccount(caller, project string) bool {
+	email, ok := strings.CutPrefix(caller, "serviceAccount:")
+	if !ok {
+		return false
+	}
+
+	return strings.HasSuffix(email, "@"+project+".iam.gserviceaccount.com") ||
+		email == project+"@appspot.gserviceaccount.com"
+}
+
+// Reports whether a binding member covers the caller, which is empty for anonymous callers
+func memberMatches(member, caller string) bool {
+	kind, value, _ := strings.Cut(member, ":")
+
+	switch {
+	case member == "allUsers":
+		return true
+	case member == "allAuthenticatedUsers":
+		return caller != ""
+	case kind == "projectOwner" || kind == "projectEditor" || kind == "projectViewer":
+		return projectServiceAccount(caller, value)
+	case strings.HasPrefix(member, "domain:"):
+		_, email, _ := strings.Cut(caller, ":")
+		return strings.HasSuffix(email, "@"+strings.TrimPrefix(member, "domain:"))
+	default:
+		return member == caller
+	}
+}
+
+// Reports whether the policy grants the caller a permission. Conditional
+// bindings grant nothing, since glocal doesn't evaluate their expressions.
+func policyAllows(bindings []PolicyBinding, caller, permission string) bool {
+	for _, binding := range bindings {
+		if binding.Condition != nil || !slices.Contains(rolePermissions[binding.Role], permission) {
+			continue
+		}
+
+		for _, member := range binding.Members {
+			if memberMatches(member, caller) {
+				return true
+			}
+		}
+	}
+
+	return false
+}
+
+// Checks a caller's permission on a bucket. Callers without an identity
+// only get what the policy grants to allUsers.
+func (s *StorageService) checkPermission(r *http.Request, bucket, permission string) error {
+	caller := s.callerIdentity(r)
+
+	attrs := s.buckets.Get(bucket, s.defaultBucketAttrs(time.Time{}))
+	if policyAllows(attrs.iamBindings(), caller, permission) {
+		return nil
+	}
+
+	if caller == "" {
+		return newAPIError(http.StatusUnauthorized, "required",
+			"Anonymous caller does not have %s access to the Google Cloud Storage bucket %s. Permission '%s' denied on resource (or it may not exist).",
+			permission, bucket, permission)
+	}
+
+	_, email, _ := strings.Cut(caller, ":")
+	return newAPIError(http.StatusForbidden, "forbidden",
+		"%s does not have %s access to the Google Cloud Storage bucket %s. Permission '%s' denied on resource (or it may not exist).",
+		email, permission, bucket, permission)
+}
+
+// Checks a caller's permission on a project. glocal keeps no project policies, so the
+// project's service accounts, which it counts as the project's owners, editors and viewers,
+// hold every project permission and other callers none.
+func (s *StorageService) checkProjectPermission(r *http.Request, project, permission string) error {
+	if project == "" {
+		return errBadRequest("Required parameter: project")
+	}
+
+	caller := s.callerIdentity(r)
+	if projectServiceAccount(caller, project) {
+		return nil
+	}
+
+	if caller == "" {
+		return newAPIError(http.StatusUnauthorized, "required",
+			"Anonymous caller does not have %s access to the Google Cloud project. Permission '%s' denied on resource (or it may not exist).",
+			permission, permission)
+	}
+
+	_, email, _ := strings.Cut(caller, ":")
+	return newAPIError(http.StatusForbidden, "forbidden",
+		"%s does not have %s access to the Google Cloud project. Permission '%s' denied on resource (or it may not exist).",
+		email, permission, permission)
+}
+
+// Wraps a project-level handler with a check of the permission it needs on the project,
+// named by the `project` path wildcard or query parameter. Does nothing unless `enforce_iam` is set.
+func (s *StorageService) authorizeProject(permission string, handler http.HandlerFunc) http.HandlerFunc {
+	if !s.config.EnforceIAM {
+		return handler
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		project := r.PathValue("project")
+		if project == "" {
+			project = r.URL.Query().Get("project")
+		}
+
+		if err := s.checkProjectPermission(r, project, permission); err != nil {
+			writeError(w, err)
+			return
+		}
+
+		handler(w, r)
+	}
+}
+
+// Wraps a handler with a check of the permission it needs on the bucket in its path
+func (s *StorageService) authorize(permission string, handler http.HandlerFunc) http.HandlerFunc {
+	return s.authorizeOn("bucket", permission, handler)
+}
+
+// Like authorize, for the bucket named by another path wildcard. Does
+// nothing unless `enforce_iam` is set.
+func (s *StorageService) authorizeOn(bucketParam, permission string, handler http.HandlerFunc) http.HandlerFunc {
+	if !s.config.EnforceIAM {
+		return handler
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if err := s.checkPermission(r, r.PathValue(bucketParam), permission); err != nil {
+			writeError(w, err)
+			return
+		}
+
+		handler(w, r)
+	}
+}
+
+// Wraps an XML API handler with a check of the permission its method needs,
+// and of read access to the source of copies
+func (s *StorageService) authorizeXML(handler http.HandlerFunc) http.HandlerFunc {
+	if !s.config.EnforceIAM {
+		return handler
+	}
+
+	return func(w http.ResponseWriter, r *http.Request) {
+		if permission := xmlPermission(r); permission != "" {
+			if err := s.checkPermission(r, r.PathValue("bucket"), permission); err != nil {
+				writeError(w, err)
+				return
+			}
+		}
+
+		if permission := xmlProjectPermission(r); permission != "" {
+			if err := s.checkProjectPermission(r, s.xmlProject(r), permission); err != nil {
+				writeError(w, err)
+				return
+			}
+		}
+
+		// Covers object copies as well as multipart part copies, which are forwarded to MinIO
+		if source := xmlCopySource(r.Header); source != "" && r.PathValue("object") != "" {
+			if err := s.checkPermission(r, xmlCopySourceBucket(source), "storage.objects.get"); err != nil {
+				writeError(w, err)
+				return
+			}
+		}
+
+		handler(w, r)
+	}
+}
+
+// Returns the permission an XML API request needs, or an empty string for
+// project-level requests such as creating or listing buckets
+func xmlPermission(r *http.Request) string {
+	if r.PathValue("bucket") == "" {
+		return ""
+	}
+
+	if r.PathValue("object") == "" {
+		switch {
+		case r.Method == http.MethodGet:
+			return "storage.objects.list"
+		case r.Method == http.MethodHead:
+			return "storage.buckets.get"
+		case r.Method == http.MethodDelete:
+			return "storage.buckets.delete"
+		case r.Method == http.MethodPost && r.URL.Query().Has("delete"):
+			return "storage.objects.delete"
+		case hasXMLSubresource(r):
+			return "storage.buckets.update"
+		}
+		return ""
+	}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+		return "storage.objects.get"
+	case http.MethodDelete:
+		return "storage.objects.delete"
+	default:
+		return "storage.objects.create"
+	}
+}
+
+// Returns the permission a project-level XML API request needs, or an empty string for the rest
+func xmlProjectPermission(r *http.Request) string {
+	switch {
+	case r.PathValue("bucket") == "" && r.Method == http.MethodGet:
+		return "storage.buckets.list"
+	case r.PathValue("object") == "" && r.Method == http.MethodPut && !hasXMLSubresource(r):
+		return "storage.buckets.create"
+	}
+
+	return ""
+}
+
+// Returns the project an XML API request acts in, named by its `x-goog-project-id`
+// header or, for requests signed with a managed HMAC key, the key's project
+func (s *StorageService) xmlProject(r *http.Request) string {
+	if project := r.Header.Get("X-Goog-Project-Id"); project != "" {
+		return project
+	}
+
+	if key, ok := s.hmacKeys.Get(requestAccessID(r)); ok {
+		return key.Project
+	}
+
+	return ""
+}
+
+func (s *StorageService) handleGetBucketIamPolicy(w http.ResponseWriter, r *http.Request) {
+	info, err := s.lookupBucket(r.Context(), r.PathValue("bucket"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	attrs := s.buckets.Get(info.Name, s.defaultBucketAttrs(info.CreationDate))
+
+	requested := 1
+	if raw := r.URL.Query().Get("optionsRequestedPolicyVersion"); raw != "" {
+		requested, err = strconv.Atoi(raw)
+		if err != nil {
+			writeError(w, errBadRequest("Invalid value for optionsRequestedPolicyVersion: %s", raw))
+			return
+		}
+	}
+
+	// Conditions can only be represented from version 3 on
+	if requested < 3 && slices.ContainsFunc(attrs.IAMBindings, func(b PolicyBinding) bool { return b.Condition != nil }) {
+		writeError(w, errBadRequest("The policy contains conditional role bindings and requires optionsRequestedPolicyVersion 3."))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, s.renderPolicy(info.Name, attrs))
+}
+
+func (s *StorageService) handleSetBucketIamPolicy(w http.ResponseWriter, r *http.Request) {
+	info, err := s.lookupBucket(r.Context(), r.PathValue("bucket"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	var policy Policy
+	if err := readJSON(r, &policy); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if err := validatePolicy(&policy); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	attrs, err := s.buckets.Update(info.Name, s.defaultBucketAttrs(info.CreationDate), func(attrs *bucketAttrs) error {
+		// A policy read before a concurrent change must not overwrite it
+		if policy.Etag != "" && policy.Etag != metagenerationEtag(attrs.Metageneration) {
+			return errPreconditionFailed()
+		}
+
+		attrs.IAMBindings = policy.Bindings
+		attrs.IAMVersion = policy.Version
+
+		return nil
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	s.syncServiceAccountPolicies(r.Context())
+
+	writeJSON(w, http.StatusOK, s.renderPolicy(info.Name, attrs))
+}
+
+func validatePolicy(policy *Policy) error {
+	if policy.Bindings == nil {
+		policy.Bindings = []PolicyBinding{}
+	}
+
+	conditional := false
+
+	for _, binding := range policy.Bindings {
+		if !strings.HasPrefix(binding.Role, "roles/") && !strings.HasPrefix(binding.Role, "projects/") &&
+			!strings.HasPrefix(binding.Role, "organizations/") {
+			return errBadRequest("Role %s is not a valid role.", binding.Role)
+		}
+
+		for _, member := range binding.Members {
+			if member != "allUsers" && member != "allAuthenticatedUsers" && !strings.Contains(member, ":") {
+				return errBadRequest("Invalid member: %s", member)
+			}
+		}
+
+		if binding.Condition != nil {
+			conditional = true
+		}
+	}
+
+	if conditional && policy.Version < 3 {
+		return errBadRequest("Conditional role bindings require policy version 3.")
+	}
+	if policy.Version == 0 {
+		policy.Version = 1
+	}
+
+	return nil
+}
+
+// Answers which of the given permissions the caller has on the bucket
+func (s *StorageService) handleTestBucketIamPermissions(w http.ResponseWriter, r *http.Request) {
+	info, err := s.lookupBucket(r.Context(), r.PathValue("bucket"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	permissions := r.URL.Query()["permissions"]
+	if len(permissions) == 0 {
+		writeError(w, errBadRequest("Required parameter: permissions"))
+		return
+	}
+
+	result := TestIamPermissionsResponse{Kind: "storage#testIamPermissionsResponse"}
+
+	for _, permission := range permissions {
+		if !strings.HasPrefix(permission, "storage.") {
+			writeError(w, errBadRequest("Permission %s is not valid.", permission))
+			return
+		}
+
+		if s.checkPermission(r, info.Name, permission) == nil {
+			result.Permissions = append(result.Permissions, permission)
+		}
+	}
+
+	writeJSON(w, http.StatusOK, result)
+}
@@ -0,0 +1,118 @@
+package storage
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestPolicyAllows(t *testing.T) {
+	defaults := bucketAttrs{Project: "my-project"}.iamBindings()
+	public := []PolicyBinding{{Role: "roles/storage.objectViewer", Members: []string{"allUsers"}}}
+	authenticated := []PolicyBinding{{Role: "roles/storage.objectViewer", Members: []string{"allAuthenticatedUsers"}}}
+	domain := []PolicyBinding{{Role: "roles/storage.objectViewer", Members: []string{"domain:example.com"}}}
+
+	tests := []struct {
+		name       string
+		bindings   []PolicyBinding
+		caller     string
+		permission string
+		allowed    bool
+	}{
+		{"project service account owns the bucket", defaults, "serviceAccount:ci@my-project.iam.gserviceaccount.com", "storage.buckets.setIamPolicy", true},
+		{"app engine service account", defaults, "serviceAccount:my-project@appspot.gserviceaccount.com", "storage.objects.create", true},
+		{"project service account reads objects", defaults, "serviceAccount:ci@my-project.iam.gserviceaccount.com", "storage.objects.get", true},
+		{"other project's service account", defaults, "serviceAccount:ci@other-project.iam.gserviceaccount.com", "storage.objects.list", false},
+		{"project-like subdomain", defaults, "serviceAccount:ci@evil.my-project.iam.gserviceaccount.com.example", "storage.objects.list", false},
+		{"users aren't project members", defaults, "user:alice@example.com", "storage.objects.list", false},
+		{"anonymous on default policy", defaults, "", "storage.objects.list", false},
+		{"anonymous on public bucket", public, "", "storage.objects.get", true},
+		{"public bucket only grants its role", public, "", "storage.objects.create", false},
+		{"anonymous isn't authenticated", authenticated, "", "storage.objects.get", false},
+		{"authenticated caller", authenticated, "user:alice@example.com", "storage.objects.get", true},
+		{"domain member", domain, "user:alice@example.com", "storage.objects.get", true},
+		{"domain outsider", domain, "user:alice@example.org", "storage.objects.get", false},
+		{"anonymous isn't in a domain", domain, "", "storage.objects.get", false},
+	}
+
+	for _, tt := range tests {
+		if got := policyAllows(tt.bindings, tt.caller, tt.permission); got != tt.allowed {
+			t.Errorf("%s: policyAllows(%q, %s) = %v, want %v", tt.name, tt.caller, tt.permission, got, tt.allowed)
+		}
+	}
+}
+
+func TestCheckPermissionAnonymous(t *testing.T) {
+	s := &StorageService{buckets: newBucketStore(), hmacKeys: newHMACKeyStore()}
+	s.buckets.Put("private", &bucketAttrs{Project: "my-project"})
+	s.buckets.Put("public", &bucketAttrs{
+		Project:     "my-project",
+		IAMBindings: []PolicyBinding{{Role: "roles/storage.objectViewer", Members: []string{"allUsers"}}},
+	})
+
+	anonymous := httptest.NewRequest("GET", "/storage/v1/b/private/o", nil)
+
+	err := s.checkPermission(anonymous, "private", "storage.objects.list")
+	if err == nil || err.(*apiError).Code != 401 {
+		t.Errorf("anonymous list of a private bucket = %v, want 401", err)
+	}
+
+	if err := s.checkPermission(anonymous, "public", "storage.objects.list"); err != nil {
+		t.Errorf("anonymous list of a public bucket = %v, want success", err)
+	}
+
+	authenticated := httptest.NewRequest("GET", "/storage/v1/b/private/o", nil)
+	authenticated.Header.Set("Authorization", "Bearer ci@my-project.iam.gserviceaccount.com")
+
+	if err := s.checkPermission(authenticated, "private", "storage.objects.list"); err != nil {
+		t.Errorf("project service account list = %v, want success", err)
+	}
+
+	authenticated.Header.Set("Authorization", "Bearer alice@example.com")
+
+	err = s.checkPermission(authenticated, "private", "storage.objects.list")
+	if err == nil || err.(*apiError).Code != 403 {
+		t.Errorf("outsider list of a private bucket = %v, want 403", err)
+	}
+}
+
+func TestXMLPermission(t *testing.T) {
+	tests := []struct {
+		method     string
+		target     string
+		permission string
+	}{
+		{"GET", "/", ""},
+		{"PUT", "/bucket", ""},
+		{"GET", "/bucket", "storage.objects.list"},
+		{"GET", "/bucket/", "storage.objects.list"},
+		{"HEAD", "/bucket", "storage.buckets.get"},
+		{"DELETE", "/bucket", "storage.buckets.delete"},
+		{"POST", "/bucket?delete", "storage.objects.delete"},
+		{"PUT", "/bucket?versioning", "storage.buckets.update"},
+		{"PUT", "/bucket/?lifecycle", "storage.buckets.update"},
+		{"PUT", "/bucket?X-Goog-Signature=abc", ""},
+		{"GET", "/bucket/object", "storage.objects.get"},
+		{"PUT", "/bucket/object", "storage.objects.create"},
+		{"POST", "/bucket/object?uploads", "storage.objects.create"},
+		{"DELETE", "/bucket/object", "storage.objects.delete"},
+	}
+
+	var permission string
+	record := func(w http.ResponseWriter, r *http.Request) { permission = xmlPermission(r) }
+
+	// Routed like the XML router, which fills in the path values
+	mux := http.NewServeMux()
+	mux.HandleFunc("/{bucket}", record)
+	mux.HandleFunc("/{bucket}/{object...}", record)
+	mux.HandleFunc("/", record)
+
+	for _, tt := range tests {
+		permission = "unrouted"
+		mux.ServeHTTP(httptest.NewRecorder(), httptest.NewRequest(tt.method, tt.target, nil))
+
+		if permission != tt.permission {
+			t.Errorf("xmlPermission(%s %s) = %q, want %q", tt.method, tt.target, permission, tt.permission)
+		}
+	}
+}
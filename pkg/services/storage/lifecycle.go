@@ -0,0 +1,364 @@
+package storage
+
+import (
+	"context"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"go.uber.org/zap"
+)
+
+const (
+	lifecycleDelete          = "Delete"
+	lifecycleSetStorageClass = "SetStorageClass"
+	lifecycleAbortUpload     = "AbortIncompleteMultipartUpload"
+)
+
+// Storage classes SetStorageClass can move objects to, from the most to the least expensive to store
+var storageClassOrder = []string{"STANDARD", "NEARLINE", "COLDLINE", "ARCHIVE"}
+
+// Checks the rules of a lifecycle configuration, returning nil for one without rules
+func validateLifecycle(lifecycle *BucketLifecycle) ([]LifecycleRule, error) {
+	if lifecycle == nil || len(lifecycle.Rule) == 0 {
+		return nil, nil
+	}
+
+	for _, rule := range lifecycle.Rule {
+		if rule.Action == nil {
+			return nil, errBadRequest("A lifecycle rule requires an action.")
+		}
+
+		cond := rule.Condition
+		if cond == nil || (cond.Age == nil && cond.CreatedBefore == "" && cond.DaysSinceCustomTime == 0 &&
+			cond.IsLive == nil && cond.NumNewerVersions == 0 && len(cond.MatchesPrefix) == 0 &&
+			len(cond.MatchesSuffix) == 0 && len(cond.MatchesStorageClass) == 0) {
+			return nil, errBadRequest("A lifecycle rule requires at least one condition.")
+		}
+
+		if (cond.Age != nil && *cond.Age < 0) || cond.DaysSinceCustomTime < 0 || cond.NumNewerVersions < 0 {
+			return nil, errBadRequest("Lifecycle condition values must not be negative.")
+		}
+
+		if cond.CreatedBefore != "" {
+			if _, err := time.Parse(time.DateOnly, cond.CreatedBefore); err != nil {
+				return nil, errBadRequest("Invalid value for createdBefore: %s", cond.CreatedBefore)
+			}
+		}
+
+		switch rule.Action.Type {
+		case lifecycleDelete:
+		case lifecycleSetStorageClass:
+			if !slices.Contains(storageClassOrder, rule.Action.StorageClass) {
+				return nil, errBadRequest("Invalid storage class for the SetStorageClass lifecycle action: %s", rule.Action.StorageClass)
+			}
+		case lifecycleAbortUpload:
+			if cond.CreatedBefore != "" || cond.DaysSinceCustomTime != 0 || cond.IsLive != nil ||
+				cond.NumNewerVersions != 0 || len(cond.MatchesStorageClass) > 0 {
+				return nil, errBadRequest("The AbortIncompleteMultipartUpload lifecycle action only supports the age, matchesPrefix and matchesSuffix conditions.")
+			}
+		default:
+			return nil, errBadRequest("Invalid lifecycle action type: %s", rule.Action.Type)
+		}
+	}
+
+	return lifecycle.Rule, nil
+}
+
+// An object version as lifecycle conditions see it
+type lifecycleObject struct {
+	info         minio.ObjectInfo
+	attrs        objectAttrs
+	storageClass string
+	live         bool
+	// Versions of the same object written after this one
+	newerVersions int64
+}
+
+// Reports whether the conditions hold for an object, measuring ages in days of the given length
+func (c *LifecycleCondition) matches(obj lifecycleObject, now time.Time, day time.Duration) bool {
+	created := time.UnixMicro(obj.attrs.Generation)
+
+	if c.Age != nil && now.Sub(created) < time.Duration(*c.Age)*day {
+		return false
+	}
+
+	if c.CreatedBefore != "" {
+		if before, err := time.Parse(time.DateOnly, c.CreatedBefore); err != nil || !created.Before(before) {
+			return false
+		}
+	}
+
+	if c.DaysSinceCustomTime > 0 && (obj.attrs.CustomTime.IsZero() || now.Sub(obj.attrs.CustomTime) < time.Duration(c.DaysSinceCustomTime)*day) {
+		return false
+	}
+
+	if c.IsLive != nil && *c.IsLive != obj.live {
+		return false
+	}
+
+	if c.NumNewerVersions > 0 && obj.newerVersions < c.NumNewerVersions {
+		return false
+	}
+
+	if len(c.MatchesStorageClass) > 0 && !slices.Contains(c.MatchesStorageClass, obj.storageClass) {
+		return false
+	}
+
+	return matchesAffixes(obj.info.Key, c.MatchesPrefix, c.MatchesSuffix)
+}
+
+func matchesAffixes(name string, prefixes, suffixes []string) bool {
+	if len(prefixes) > 0 && !slices.ContainsFunc(prefixes, func(prefix string) bool { return strings.HasPrefix(name, prefix) }) {
+		return false
+	}
+
+	if len(suffixes) > 0 && !slices.ContainsFunc(suffixes, func(suffix string) bool { return strings.HasSuffix(name, suffix) }) {
+		return false
+	}
+
+	return true
+}
+
+// Picks the action GCS takes when several rules match: deletion wins over
+// storage class changes, and among those the cheapest class to store wins
+func lifecycleAction(rules []LifecycleRule, obj lifecycleObject, now time.Time, day time.Duration) *LifecycleAction {
+	var action *LifecycleAction
+
+	for _, rule := range rules {
+		if rule.Action.Type == lifecycleAbortUpload || !rule.Condition.matches(obj, now, day) {
+			continue
+		}
+
+		if rule.Action.Type == lifecycleDelete {
+			return rule.Action
+		}
+
+		// Moving an object to the class it already has changes nothing
+		if rule.Action.StorageClass == obj.storageClass {
+			continue
+		}
+
+		if action == nil || slices.Index(storageClassOrder, rule.Action.StorageClass) > slices.Index(storageClassOrder, action.StorageClass) {
+			action = rule.Action
+		}
+	}
+
+	return action
+}
+
+// Periodically applies the lifecycle rules of every bucket
+func (s *StorageService) runLifecycle(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			for bucket, rules := range s.buckets.Lifecycles() {
+				if err := s.applyLifecycle(ctx, bucket, rules, now); err != nil {
+					s.logger.Warn("Failed to apply lifecycle rules", zap.String("bucket", bucket), zap.Error(err))
+				}
+			}
+		}
+	}
+}
+
+func (s *StorageService) applyLifecycle(ctx context.Context, bucket string, rules []LifecycleRule, now time.Time) error {
+	day := s.config.LifecycleDayLength
+	defaultClass := s.buckets.Get(bucket, s.defaultBucketAttrs(time.Time{})).StorageClass
+
+	type pending struct {
+		obj    lifecycleObject
+		action *LifecycleAction
+	}
+
+	// Decide on every version first, since acting while listing would shift the listing
+	var actions []pending
+	var key string
+	var newer int64
+
+	err := s.walkObjects(ctx, bucket, minio.ListObjectsOptions{
+		Recursive:    true,
+		WithVersions: true,
+		WithMetadata: true,
+	}, func(info minio.ObjectInfo) bool {
+		// Versions of an object are listed newest first
+		if info.Key != key {
+			key = info.Key
+			newer = 0
+		}
+
+		if info.IsDeleteMarker {
+			return true
+		}
+
+		obj := lifecycleObject{
+			info:          info,
+			attrs:         parseObjectAttrs(info),
+			live:          info.IsLatest,
+			newerVersions: newer,
+		}
+		obj.storageClass = obj.attrs.StorageClass
+		if obj.storageClass == "" {
+			obj.storageClass = defaultClass
+		}
+		newer++
+
+		if action := lifecycleAction(rules, obj, now, day); action != nil {
+			actions = append(actions, pending{obj, action})
+		}
+
+		return true
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, p := range actions {
+		if err := s.applyLifecycleAction(ctx, bucket, p.obj, p.action); err != nil {
+			s.logger.Warn("Failed to apply lifecycle action",
+				zap.String("bucket", bucket),
+				zap.String("object", p.obj.info.Key),
+				zap.String("action", p.action.Type),
+				zap.Error(err))
+		}
+	}
+
+	return s.abortIncompleteUploads(ctx, bucket, rules, now)
+}
+
+func (s *StorageService) applyLifecycleAction(ctx context.Context, bucket string, obj lifecycleObject, action *LifecycleAction) error {
+	unlock := s.locks.Lock(bucket, obj.info.Key)
+	defer unlock()
+
+	if !obj.live {
+		// Only deletion applies to noncurrent versions, since rewriting
+		// their metadata would make them live again
+		if action.Type != lifecycleDelete {
+			return nil
+		}
+
+		// Held and retained versions are left alone until they are released
+		if s.checkRetention(bucket, obj.info.Key, obj.attrs) != nil {
+			return nil
+		}
+
+		if err := s.softDelete(ctx, bucket, obj.info); err != nil {
+			return err
+		}
+
+		if err := s.client.RemoveObject(ctx, bucket, obj.info.Key, minio.RemoveObjectOptions{VersionID: obj.info.VersionID}); err != nil {
+			s.removeSoftDeleted(context.WithoutCancel(ctx), bucket, obj.info)
+			return err
+		}
+
+		s.removeObjectACL(bucket, obj.info, true)
+		s.notifyRemoval(bucket, obj.info, true, nil)
+		return nil
+	}
+
+	// Skip objects that were deleted or overwritten since they were listed
+	current, err := s.client.StatObject(ctx, bucket, obj.info.Key, minio.StatObjectOptions{})
+	if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	attrs := parseObjectAttrs(current)
+	if attrs.Generation != obj.attrs.Generation {
+		return nil
+	}
+
+	if action.Type == lifecycleDelete {
+		if s.checkRetention(bucket, obj.info.Key, attrs) != nil {
+			return nil
+		}
+
+		softDeleted := !s.buckets.Get(bucket, s.defaultBucketAttrs(time.Time{})).Versioning
+		if softDeleted {
+			if err := s.softDelete(ctx, bucket, current); err != nil {
+				return err
+			}
+		}
+
+		// Versioned buckets keep the object as a noncurrent version, like GCS does
+		if err := s.client.RemoveObject(ctx, bucket, obj.info.Key, minio.RemoveObjectOptions{}); err != nil {
+			if softDeleted {
+				s.removeSoftDeleted(context.WithoutCancel(ctx), bucket, current)
+			}
+			return err
+		}
+
+		s.removeObjectACL(bucket, current, false)
+		s.notifyRemoval(bucket, current, false, nil)
+		return nil
+	}
+
+	attrs.StorageClass = action.StorageClass
+	info, err := s.replaceObjectAttrs(ctx, bucket, obj.info.Key, attrs)
+	if err != nil {
+		return err
+	}
+
+	s.notify(bucket, eventMetadataUpdate, info, nil)
+
+	return nil
+}
+
+// Aborts the multipart uploads of XML API clients that match an AbortIncompleteMultipartUpload rule.
+// Uploads backing resumable upload sessions are left to expire with their session.
+func (s *StorageService) abortIncompleteUploads(ctx context.Context, bucket string, rules []LifecycleRule, now time.Time) error {
+	var abortRules []LifecycleRule
+	for _, rule := range rules {
+		if rule.Action.Type == lifecycleAbortUpload {
+			abortRules = append(abortRules, rule)
+		}
+	}
+
+	if len(abortRules) == 0 {
+		return nil
+	}
+
+	// Stops the listing's producer when the loop returns early
+	listCtx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	var uploads []minio.ObjectMultipartInfo
+	for upload := range s.client.ListIncompleteUploads(listCtx, bucket, "", true) {
+		if upload.Err != nil {
+			return upload.Err
+		}
+
+		if s.uploads.HasUpload(upload.UploadID) {
+			continue
+		}
+
+		for _, rule := range abortRules {
+			cond := rule.Condition
+			if cond.Age != nil && now.Sub(upload.Initiated) < time.Duration(*cond.Age)*s.config.LifecycleDayLength {
+				continue
+			}
+
+			if matchesAffixes(upload.Key, cond.MatchesPrefix, cond.MatchesSuffix) {
+				uploads = append(uploads, upload)
+				break
+			}
+		}
+	}
+
+	for _, upload := range uploads {
+		if err := s.core.AbortMultipartUpload(ctx, bucket, upload.Key, upload.UploadID); err != nil {
+			s.logger.Warn("Failed to abort incomplete multipart upload",
+				zap.String("bucket", bucket),
+				zap.String("object", upload.Key),
+				zap.Error(err))
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,342 @@
+package storage
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// GCS object attributes without an S3 equivalent are kept as MinIO user
+// metadata under these keys, and hidden from the GCS `metadata` field
+const (
+	metaPrefix         = "Glocal-"
+	metaGeneration     = metaPrefix + "Generation"
+	metaMetageneration = metaPrefix + "Metageneration"
+	metaCRC32C         = metaPrefix + "Crc32c"
+	metaMD5            = metaPrefix + "Md5"
+	metaComponentCount = metaPrefix + "Component-Count"
+	metaCustomTime     = metaPrefix + "Custom-Time"
+	metaUpdated        = metaPrefix + "Updated"
+	metaStorageClass   = metaPrefix + "Storage-Class"
+	metaTemporaryHold  = metaPrefix + "Temporary-Hold"
+	metaEventHold      = metaPrefix + "Event-Based-Hold"
+	metaHoldReleased   = metaPrefix + "Hold-Released"
+	metaRetentionMode  = metaPrefix + "Retention-Mode"
+	metaRetainUntil    = metaPrefix + "Retain-Until"
+	metaKeySHA256      = metaPrefix + "Encryption-Key-Sha256"
+	metaEncryptionIV   = metaPrefix + "Encryption-Iv"
+	metaKMSKeyName     = metaPrefix + "Kms-Key-Name"
+	metaSoftDeleteTime = metaPrefix + "Soft-Delete-Time"
+	metaHardDeleteTime = metaPrefix + "Hard-Delete-Time"
+
+	// S3 canonicalizes metadata keys, so GCS custom metadata is stored as a single JSON blob to keep its casing
+	metaCustom = metaPrefix + "Metadata"
+)
+
+// Attributes of a stored object, as GCS sees them
+type objectAttrs struct {
+	Generation         int64
+	Metageneration     int64
+	CRC32C             string
+	MD5                string
+	ContentType        string
+	ContentEncoding    string
+	ContentDisposition string
+	ContentLanguage    string
+	CacheControl       string
+	CustomTime         time.Time
+	Updated            time.Time
+	Metadata           map[string]string
+
+	// Storage class the object was written with or moved to, empty for the bucket's default
+	StorageClass string
+
+	// Number of components of a composite object, 0 for objects that were uploaded whole
+	ComponentCount int
+
+	TemporaryHold  bool
+	EventBasedHold bool
+	// When the event-based hold was last released, which restarts the bucket's retention period
+	HoldReleased time.Time
+
+	// The object's own retention configuration, if any
+	RetentionMode string
+	RetainUntil   time.Time
+
+	// SHA256 of the customer-supplied key the data is encrypted with, and the IV it was encrypted under
+	KeySHA256    string
+	EncryptionIV []byte
+	// Cloud KMS key name the object was written with, recorded but not used for encryption
+	KMSKeyName string
+
+	// When a soft-deleted generation was deleted, and when it will be removed for good
+	SoftDeleted time.Time
+	HardDeleted time.Time
+}
+
+// Returns the object's user metadata with the `X-Amz-Meta-` prefix stripped.
+// MinIO strips it on HEAD responses but keeps it in metadata listings.
+func userMetadata(info minio.ObjectInfo) map[string]string {
+	result := make(map[string]string, len(info.UserMetadata))
+
+	for key, value := range info.UserMetadata {
+		if len(key) > len("X-Amz-Meta-") && strings.EqualFold(key[:len("X-Amz-Meta-")], "X-Amz-Meta-") {
+			key = key[len("X-Amz-Meta-"):]
+		}
+
+		result[http.CanonicalHeaderKey(key)] = value
+	}
+
+	return result
+}
+
+// Looks up a standard header such as Cache-Control, which HEAD
+// responses carry as headers and listings as user metadata
+func objectHeader(info minio.ObjectInfo, meta map[string]string, name string) string {
+	if value := info.Metadata.Get(name); value != "" {
+		return value
+	}
+
+	return meta[http.CanonicalHeaderKey(name)]
+}
+
+func parseObjectAttrs(info minio.ObjectInfo) objectAttrs {
+	meta := userMetadata(info)
+
+	attrs := objectAttrs{
+		// Last-Modified headers only carry seconds, so truncate to agree with listings
+		Generation:         info.LastModified.Truncate(time.Second).UnixMicro(),
+		Metageneration:     1,
+		CRC32C:             meta[metaCRC32C],
+		MD5:                meta[metaMD5],
+		ContentType:        info.ContentType,
+		ContentEncoding:    objectHeader(info, meta, "Content-Encoding"),
+		ContentDisposition: objectHeader(info, meta, "Content-Disposition"),
+		ContentLanguage:    objectHeader(info, meta, "Content-Language"),
+		CacheControl:       objectHeader(info, meta, "Cache-Control"),
+		StorageClass:       meta[metaStorageClass],
+		TemporaryHold:      meta[metaTemporaryHold] == "true",
+		EventBasedHold:     meta[metaEventHold] == "true",
+		RetentionMode:      meta[metaRetentionMode],
+		KeySHA256:          meta[metaKeySHA256],
+		KMSKeyName:         meta[metaKMSKeyName],
+	}
+
+	if attrs.ContentType == "" {
+		attrs.ContentType = meta["Content-Type"]
+	}
+
+	if generation, err := strconv.ParseInt(meta[metaGeneration], 10, 64); err == nil {
+		attrs.Generation = generation
+	}
+
+	if metageneration, err := strconv.ParseInt(meta[metaMetageneration], 10, 64); err == nil {
+		attrs.Metageneration = metageneration
+	}
+
+	// MinIO's Last-Modified only has second precision, which would put
+	// the update time before the creation time of new objects
+	attrs.Updated = info.LastModified
+	if updated, err := time.Parse(time.RFC3339Nano, meta[metaUpdated]); err == nil {
+		attrs.Updated = updated
+	}
+
+	if customTime, err := time.Parse(time.RFC3339Nano, meta[metaCustomTime]); err == nil {
+		attrs.CustomTime = customTime
+	}
+
+	if released, err := time.Parse(time.RFC3339Nano, meta[metaHoldReleased]); err == nil {
+		attrs.HoldReleased = released
+	}
+
+	if retainUntil, err := time.Parse(time.RFC3339Nano, meta[metaRetainUntil]); err == nil {
+		attrs.RetainUntil = retainUntil
+	}
+
+	if softDeleted, err := time.Parse(time.RFC3339Nano, meta[metaSoftDeleteTime]); err == nil {
+		attrs.SoftDeleted = softDeleted
+	}
+
+	if hardDeleted, err := time.Parse(time.RFC3339Nano, meta[metaHardDeleteTime]); err == nil {
+		attrs.HardDeleted = hardDeleted
+	}
+
+	if iv, err := base64.StdEncoding.DecodeString(meta[metaEncryptionIV]); err == nil && len(iv) > 0 {
+		attrs.EncryptionIV = iv
+	}
+
+	if componentCount, err := strconv.Atoi(meta[metaComponentCount]); err == nil {
+		attrs.ComponentCount = componentCount
+	}
+
+	if attrs.CRC32C == "" {
+		attrs.CRC32C = info.ChecksumCRC32C
+	}
+
+	// Composite objects have no MD5 hash in GCS
+	if attrs.MD5 == "" && attrs.ComponentCount == 0 {
+		attrs.MD5 = etagToMD5(info.ETag)
+	}
+
+	if encoded, ok := meta[metaCustom]; ok {
+		attrs.Metadata = decodeCustomMetadata(encoded)
+	} else {
+		// Objects written through the S3 API carry plain user metadata
+		for key, value := range meta {
+			if strings.HasPrefix(key, metaPrefix) || strings.HasPrefix(key, "Content-") || strings.HasPrefix(key, "X-Amz-") {
+				continue
+			}
+
+			if attrs.Metadata == nil {
+				attrs.Metadata = make(map[string]string)
+			}
+			attrs.Metadata[key] = value
+		}
+	}
+
+	return attrs
+}
+
+// Builds the MinIO write options that persist attrs alongside the object data
+func (a objectAttrs) putOptions() minio.PutObjectOptions {
+	return minio.PutObjectOptions{
+		UserMetadata:       a.userMetadata(),
+		ContentType:        a.ContentType,
+		ContentEncoding:    a.ContentEncoding,
+		ContentDisposition: a.ContentDisposition,
+		ContentLanguage:    a.ContentLanguage,
+		CacheControl:       a.CacheControl,
+	}
+}
+
+// Builds copy options that replace the destination's metadata with attrs.
+// minio-go sends standard headers such as Content-Type as-is and prefixes the rest.
+func (a objectAttrs) copyDestOptions(bucket, name string) minio.CopyDestOptions {
+	meta := a.userMetadata()
+
+	for header, value := range map[string]string{
+		"Content-Type":        a.ContentType,
+		"Content-Encoding":    a.ContentEncoding,
+		"Content-Disposition": a.ContentDisposition,
+		"Content-Language":    a.ContentLanguage,
+		"Cache-Control":       a.CacheControl,
+	} {
+		if value != "" {
+			meta[header] = value
+		}
+	}
+
+	return minio.CopyDestOptions{
+		Bucket:          bucket,
+		Object:          name,
+		UserMetadata:    meta,
+		ReplaceMetadata: true,
+	}
+}
+
+func (a objectAttrs) userMetadata() map[string]string {
+	meta := map[string]string{
+		metaGeneration:     strconv.FormatInt(a.Generation, 10),
+		metaMetageneration: strconv.FormatInt(a.Metageneration, 10),
+	}
+
+	if a.CRC32C != "" {
+		meta[metaCRC32C] = a.CRC32C
+	}
+	if a.MD5 != "" {
+		meta[metaMD5] = a.MD5
+	}
+	if len(a.Metadata) > 0 {
+		meta[metaCustom] = encodeCustomMetadata(a.Metadata)
+	}
+	if a.ComponentCount > 0 {
+		meta[metaComponentCount] = strconv.Itoa(a.ComponentCount)
+	}
+	if !a.CustomTime.IsZero() {
+		meta[metaCustomTime] = a.CustomTime.UTC().Format(time.RFC3339Nano)
+	}
+	if !a.Updated.IsZero() {
+		meta[metaUpdated] = a.Updated.UTC().Format(time.RFC3339Nano)
+	}
+	if a.StorageClass != "" {
+		meta[metaStorageClass] = a.StorageClass
+	}
+	if a.TemporaryHold {
+		meta[metaTemporaryHold] = "true"
+	}
+	if a.EventBasedHold {
+		meta[metaEventHold] = "true"
+	}
+	if !a.HoldReleased.IsZero() {
+		meta[metaHoldReleased] = a.HoldReleased.UTC().Format(time.RFC3339Nano)
+	}
+	if a.RetentionMode != "" {
+		meta[metaRetentionMode] = a.RetentionMode
+		meta[metaRetainUntil] = a.RetainUntil.UTC().Format(time.RFC3339Nano)
+	}
+	if a.KeySHA256 != "" {
+		meta[metaKeySHA256] = a.KeySHA256
+		meta[metaEncryptionIV] = base64.StdEncoding.EncodeToString(a.EncryptionIV)
+	}
+	if a.KMSKeyName != "" {
+		meta[metaKMSKeyName] = a.KMSKeyName
+	}
+	if !a.SoftDeleted.IsZero() {
+		meta[metaSoftDeleteTime] = a.SoftDeleted.UTC().Format(time.RFC3339Nano)
+		meta[metaHardDeleteTime] = a.HardDeleted.UTC().Format(time.RFC3339Nano)
+	}
+
+	return meta
+}
+
+// Sets customTime from its RFC 3339 form. Like GCS, a custom time can
+// only move forward once it has been set.
+func (a *objectAttrs) setCustomTime(value string) error {
+	var customTime time.Time
+	if value != "" {
+		parsed, err := time.Parse(time.RFC3339Nano, value)
+		if err != nil {
+			return errBadRequest("Invalid value for customTime: %s", value)
+		}
+		customTime = parsed
+	}
+
+	if !a.CustomTime.IsZero() && (customTime.IsZero() || customTime.Before(a.CustomTime)) {
+		return errBadRequest("The customTime of an object can't be removed or decreased.")
+	}
+
+	a.CustomTime = customTime
+	return nil
+}
+
+// Starts a new generation of the object. Generations are microsecond
+// timestamps like the ones GCS hands out, and double as the creation time.
+func (a *objectAttrs) startGeneration() {
+	a.Generation = time.Now().UnixMicro()
+	a.Metageneration = 1
+	a.Updated = time.UnixMicro(a.Generation)
+}
+
+func encodeCustomMetadata(metadata map[string]string) string {
+	encoded, _ := json.Marshal(metadata)
+	return base64.StdEncoding.EncodeToString(encoded)
+}
+
+func decodeCustomMetadata(encoded string) map[string]string {
+	raw, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil {
+		return nil
+	}
+
+	var metadata map[string]string
+	if err := json.Unmarshal(raw, &metadata); err != nil {
+		return nil
+	}
+
+	return metadata
+}
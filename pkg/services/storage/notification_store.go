@@ -0,0 +1,85 @@
+package storage
+
+import (
+	"maps"
+	"slices"
+	"strconv"
+	"sync"
+)
+
+// Keeps bucket notification configs for the lifetime of the MinIO container
+type notificationStore struct {
+	configs map[string][]Notification
+	nextID  int64
+	mu      sync.RWMutex
+}
+
+func newNotificationStore() *notificationStore {
+	return &notificationStore{
+		configs: make(map[string][]Notification),
+	}
+}
+
+// Stores a new config under the next free ID and returns it
+func (ns *notificationStore) Add(bucket string, config Notification) Notification {
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+
+	ns.nextID++
+	config.ID = strconv.FormatInt(ns.nextID, 10)
+	config.EventTypes = slices.Clone(config.EventTypes)
+	config.CustomAttributes = maps.Clone(config.CustomAttributes)
+
+	ns.configs[bucket] = append(ns.configs[bucket], config)
+
+	return config
+}
+
+func (ns *notificationStore) List(bucket string) []Notification {
+	ns.mu.RLock()
+	defer ns.mu.RUnlock()
+
+	return slices.Clone(ns.configs[bucket])
+}
+
+func (ns *notificationStore) Get(bucket, id string) (Notification, bool) {
+	ns.mu.RLock()
+	defer ns.mu.RUnlock()
+
+	i := slices.IndexFunc(ns.configs[bucket], func(config Notification) bool { return config.ID == id })
+	if i < 0 {
+		return Notification{}, false
+	}
+
+	return ns.configs[bucket][i], true
+}
+
+// Reports whether any config watches the bucket
+func (ns *notificationStore) Has(bucket string) bool {
+	ns.mu.RLock()
+	defer ns.mu.RUnlock()
+
+	return len(ns.configs[bucket]) > 0
+}
+
+func (ns *notificationStore) Delete(bucket, id string) bool {
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+
+	configs := ns.configs[bucket]
+	i := slices.IndexFunc(configs, func(config Notification) bool { return config.ID == id })
+	if i < 0 {
+		return false
+	}
+
+	ns.configs[bucket] = slices.Delete(slices.Clone(configs), i, i+1)
+
+	return true
+}
+
+func (ns *notificationStore) DeleteBucket(bucket string) {
+	ns.mu.Lock()
+	defer ns.mu.Unlock()
+
+	delete(ns.configs, bucket)
+}
@@ -0,0 +1,254 @@
+package storage
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"maps"
+	"net/http"
+	"net/url"
+	"regexp"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"go.uber.org/zap"
+)
+
+// Object change events notification configs can subscribe to
+const (
+	eventFinalize       = "OBJECT_FINALIZE"
+	eventMetadataUpdate = "OBJECT_METADATA_UPDATE"
+	eventDelete         = "OBJECT_DELETE"
+	eventArchive        = "OBJECT_ARCHIVE"
+)
+
+var notificationEventTypes = []string{eventFinalize, eventMetadataUpdate, eventDelete, eventArchive}
+
+// Payload formats of notification messages: the object resource, or no payload at all
+const (
+	payloadJSON = "JSON_API_V1"
+	payloadNone = "NONE"
+)
+
+var topicPattern = regexp.MustCompile(`^projects/[^/]+/topics/[^/]+$`)
+
+// Events may be published outside of any request, e.g. by the lifecycle
+// sweeper, so payloads link to the public endpoint like GCS payloads do
+var notificationRequest = &http.Request{
+	URL:    &url.URL{},
+	Host:   "www.googleapis.com",
+	Header: http.Header{"X-Forwarded-Proto": {"https"}},
+}
+
+func renderNotification(r *http.Request, bucket string, config Notification) *Notification {
+	config.Kind = "storage#notification"
+	config.SelfLink = bucketSelfLink(r, bucket) + "/notificationConfigs/" + url.PathEscape(config.ID)
+	config.Etag = config.ID
+
+	return &config
+}
+
+func (s *StorageService) handleListNotifications(w http.ResponseWriter, r *http.Request) {
+	info, err := s.lookupBucket(r.Context(), r.PathValue("bucket"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	result := &Notifications{
+		Kind:  "storage#notifications",
+		Items: []*Notification{},
+	}
+
+	for _, config := range s.notifications.List(info.Name) {
+		result.Items = append(result.Items, renderNotification(r, info.Name, config))
+	}
+
+	writeJSON(w, http.StatusOK, result)
+}
+
+func (s *StorageService) handleInsertNotification(w http.ResponseWriter, r *http.Request) {
+	info, err := s.lookupBucket(r.Context(), r.PathValue("bucket"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	var req Notification
+	if err := readJSON(r, &req); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	topic := strings.TrimPrefix(req.Topic, pubsubTopicPrefix)
+	if !topicPattern.MatchString(topic) {
+		writeError(w, errBadRequest("Invalid Pub/Sub topic: %s", req.Topic))
+		return
+	}
+
+	if req.PayloadFormat == "" {
+		req.PayloadFormat = payloadJSON
+	}
+	if req.PayloadFormat != payloadJSON && req.PayloadFormat != payloadNone {
+		writeError(w, errBadRequest("Invalid payload format: %s", req.PayloadFormat))
+		return
+	}
+
+	for _, eventType := range req.EventTypes {
+		if !slices.Contains(notificationEventTypes, eventType) {
+			writeError(w, errBadRequest("Invalid event type: %s", eventType))
+			return
+		}
+	}
+
+	config := s.notifications.Add(info.Name, Notification{
+		Topic:            pubsubTopicPrefix + topic,
+		EventTypes:       req.EventTypes,
+		CustomAttributes: req.CustomAttributes,
+		PayloadFormat:    req.PayloadFormat,
+		ObjectNamePrefix: req.ObjectNamePrefix,
+	})
+
+	writeJSON(w, http.StatusOK, renderNotification(r, info.Name, config))
+}
+
+func (s *StorageService) handleGetNotification(w http.ResponseWriter, r *http.Request) {
+	info, err := s.lookupBucket(r.Context(), r.PathValue("bucket"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	config, exists := s.notifications.Get(info.Name, r.PathValue("notification"))
+	if !exists {
+		writeError(w, errNotFound("No such notification config: %s", r.PathValue("notification")))
+		return
+	}
+
+	writeJSON(w, http.StatusOK, renderNotification(r, info.Name, config))
+}
+
+func (s *StorageService) handleDeleteNotification(w http.ResponseWriter, r *http.Request) {
+	info, err := s.lookupBucket(r.Context(), r.PathValue("bucket"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if !s.notifications.Delete(info.Name, r.PathValue("notification")) {
+		writeError(w, errNotFound("No such notification config: %s", r.PathValue("notification")))
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Publishes an event about an object version to every config of the bucket that watches it.
+// Only changes made through glocal are reported, not those made by S3 requests forwarded to MinIO.
+func (s *StorageService) notify(bucket, eventType string, info minio.ObjectInfo, extra map[string]string) {
+	configs := s.notifications.List(bucket)
+	if len(configs) == 0 {
+		return
+	}
+
+	generation := strconv.FormatInt(parseObjectAttrs(info).Generation, 10)
+	eventTime := time.Now().UTC().Format(time.RFC3339Nano)
+
+	var payload string
+
+	for _, config := range configs {
+		if len(config.EventTypes) > 0 && !slices.Contains(config.EventTypes, eventType) {
+			continue
+		}
+		if !strings.HasPrefix(info.Key, config.ObjectNamePrefix) {
+			continue
+		}
+
+		attributes := make(map[string]string, len(config.CustomAttributes)+len(extra)+7)
+		maps.Copy(attributes, config.CustomAttributes)
+		maps.Copy(attributes, extra)
+		attributes["notificationConfig"] = "projects/_/buckets/" + bucket + "/notificationConfigs/" + config.ID
+		attributes["eventType"] = eventType
+		attributes["payloadFormat"] = config.PayloadFormat
+		attributes["bucketId"] = bucket
+		attributes["objectId"] = info.Key
+		attributes["objectGeneration"] = generation
+		attributes["eventTime"] = eventTime
+
+		message := pubsubMessage{Attributes: attributes}
+		if config.PayloadFormat == payloadJSON {
+			if payload == "" {
+				encoded, err := json.Marshal(s.renderObject(notificationRequest, bucket, info))
+				if err != nil {
+					s.logger.Warn("Failed to encode notification payload", zap.String("bucket", bucket), zap.Error(err))
+					continue
+				}
+				payload = base64.StdEncoding.EncodeToString(encoded)
+			}
+			message.Data = payload
+		}
+
+		s.publisher.Publish(config.Topic, message)
+	}
+}
+
+// Returns the live version a write is about to replace, when the bucket has notification
+// configs that would report it. Unversioned buckets with a soft delete policy keep it as
+// soft-deleted, which has to happen before the write overwrites it, so callers run undo
+// when the write fails to drop that copy again.
+func (s *StorageService) replacedVersion(ctx context.Context, bucket, name string) (replaced *minio.ObjectInfo, undo func(), err error) {
+	undo = func() {}
+
+	attrs := s.buckets.Get(bucket, s.defaultBucketAttrs(time.Time{}))
+	softDelete := attrs.SoftDeleteRetention > 0 && !attrs.Versioning
+
+	if !s.notifications.Has(bucket) && !softDelete {
+		return nil, undo, nil
+	}
+
+	info, err := s.client.StatObject(ctx, bucket, name, minio.StatObjectOptions{})
+	if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+		return nil, undo, nil
+	}
+	if err != nil {
+		return nil, undo, err
+	}
+
+	if softDelete {
+		if err := s.softDelete(ctx, bucket, info); err != nil {
+			return nil, undo, err
+		}
+		undo = func() { s.removeSoftDeleted(context.WithoutCancel(ctx), bucket, info) }
+	}
+
+	return &info, undo, nil
+}
+
+// Reports a new generation of an object along with the version it replaced, if any
+func (s *StorageService) notifyFinalize(bucket string, replaced *minio.ObjectInfo, info minio.ObjectInfo) {
+	if replaced == nil {
+		s.notify(bucket, eventFinalize, info, nil)
+		return
+	}
+
+	s.notify(bucket, eventFinalize, info, map[string]string{
+		"overwroteGeneration": strconv.FormatInt(parseObjectAttrs(*replaced).Generation, 10),
+	})
+	s.notifyRemoval(bucket, *replaced, false, map[string]string{
+		"overwrittenByGeneration": strconv.FormatInt(parseObjectAttrs(info).Generation, 10),
+	})
+}
+
+// Reports a version that stopped being live. Versioned buckets keep it as
+// a noncurrent version unless it was removed for good.
+func (s *StorageService) notifyRemoval(bucket string, info minio.ObjectInfo, permanent bool, extra map[string]string) {
+	eventType := eventDelete
+	if !permanent && s.buckets.Get(bucket, s.defaultBucketAttrs(time.Time{})).Versioning {
+		eventType = eventArchive
+	}
+
+	s.notify(bucket, eventType, info, extra)
+}
@@ -0,0 +1,49 @@
+package storage
+
+import "sync"
+
+type objectLock struct {
+	mu   sync.Mutex
+	refs int
+}
+
+// Serializes writes to the same object, so that precondition checks and the
+// write they guard happen atomically from a client's point of view
+type objectLocks struct {
+	locks map[string]*objectLock
+	mu    sync.Mutex
+}
+
+func newObjectLocks() *objectLocks {
+	return &objectLocks{
+		locks: make(map[string]*objectLock),
+	}
+}
+
+// Locks the object and returns the function releasing it
+func (ol *objectLocks) Lock(bucket, name string) func() {
+	key := bucket + "/" + name
+
+	ol.mu.Lock()
+	lock, exists := ol.locks[key]
+	if !exists {
+		lock = &objectLock{}
+		ol.locks[key] = lock
+	}
+	lock.refs++
+	ol.mu.Unlock()
+
+	lock.mu.Lock()
+
+	return func() {
+		lock.mu.Unlock()
+
+		ol.mu.Lock()
+		defer ol.mu.Unlock()
+
+		lock.refs--
+		if lock.refs == 0 {
+			delete(ol.locks, key)
+		}
+	}
+}
@@ -0,0 +1,663 @@
+package storage
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+	"unicode/utf8"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// Parsed query parameters of an objects.list call
+type listQuery struct {
+	Prefix                   string
+	Delimiter                string
+	StartOffset              string
+	EndOffset                string
+	IncludeTrailingDelimiter bool
+	MatchGlob                *regexp.Regexp
+	Versions                 bool
+	MaxResults               int
+	StartAfter               string
+
+	// Set when a page of versions ended partway through an object's generations
+	StartAfterGeneration int64
+}
+
+func parseListQuery(r *http.Request) (listQuery, error) {
+	query := r.URL.Query()
+
+	lq := listQuery{
+		Prefix:                   query.Get("prefix"),
+		Delimiter:                query.Get("delimiter"),
+		StartOffset:              query.Get("startOffset"),
+		EndOffset:                query.Get("endOffset"),
+		IncludeTrailingDelimiter: query.Get("includeTrailingDelimiter") == "true",
+		Versions:                 query.Get("versions") == "true",
+	}
+
+	maxResults, err := parseMaxResults(query.Get("maxResults"), 1000)
+	if err != nil {
+		return listQuery{}, err
+	}
+	lq.MaxResults = maxResults
+
+	lq.StartAfter, err = decodePageToken(query.Get("pageToken"))
+	if err != nil {
+		return listQuery{}, err
+	}
+
+	// Version page tokens carry the last generation after a newline, which object names can't contain
+	if name, generation, found := strings.Cut(lq.StartAfter, "\n"); found {
+		lq.StartAfter = name
+		lq.StartAfterGeneration, err = strconv.ParseInt(generation, 10, 64)
+		if err != nil {
+			return listQuery{}, errBadRequest("Invalid page token")
+		}
+	}
+
+	if glob := query.Get("matchGlob"); glob != "" {
+		lq.MatchGlob, err = compileGlob(glob)
+		if err != nil {
+			return listQuery{}, err
+		}
+	}
+
+	return lq, nil
+}
+
+// Returns the exclusive S3 StartAfter key that covers both the page token and startOffset
+func (lq listQuery) s3StartAfter() string {
+	startAfter := lq.StartAfter
+
+	// S3 StartAfter is exclusive while startOffset is inclusive, so start
+	// just before it and let the name filter drop anything still below it
+	if lq.StartOffset != "" {
+		_, size := utf8.DecodeLastRuneInString(lq.StartOffset)
+		beforeOffset := lq.StartOffset[:len(lq.StartOffset)-size]
+		if beforeOffset > startAfter {
+			startAfter = beforeOffset
+		}
+	}
+
+	return startAfter
+}
+
+// Reports whether an entry was already returned on an earlier page
+func (lq listQuery) seen(name string, generation int64) bool {
+	if lq.StartAfterGeneration == 0 {
+		return name <= lq.StartAfter
+	}
+
+	return name < lq.StartAfter || (name == lq.StartAfter && generation <= lq.StartAfterGeneration)
+}
+
+// Iterates over objects in listing order until fn returns false, draining the channel afterwards
+func (s *StorageService) walkObjects(ctx context.Context, bucket string, opts minio.ListObjectsOptions, fn func(minio.ObjectInfo) bool) error {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+
+	objects := s.client.ListObjects(ctx, bucket, opts)
+
+	var walkErr error
+	stopped := false
+
+	for info := range objects {
+		if stopped {
+			continue
+		}
+
+		if info.Err != nil {
+			walkErr = info.Err
+		} else if fn(info) {
+			continue
+		}
+
+		stopped = true
+		cancel()
+	}
+
+	return walkErr
+}
+
+// Lists a bucket the way GCS does. Prefixes are grouped on glocal's side so any
+// delimiter works and items and prefixes are paged in a single lexical order.
+func (s *StorageService) listObjects(ctx context.Context, r *http.Request, bucket string, lq listQuery) (*Objects, error) {
+	result := &Objects{
+		Kind:  "storage#objects",
+		Items: []*Object{},
+	}
+
+	count := 0
+	lastToken := ""
+	startAfter := lq.s3StartAfter()
+
+	// Versions are listed oldest first, so each version is noncurrent from
+	// the moment the next one of the same object was written
+	var previous *Object
+
+	for {
+		// Set once a prefix has been emitted, so that listing can skip past its contents
+		resumeAfter := ""
+		// Version listings can't start after a key, so they walk through a prefix's contents instead
+		skipPrefix := ""
+
+		err := s.walkObjects(ctx, bucket, minio.ListObjectsOptions{
+			Prefix:          lq.Prefix,
+			StartAfter:      startAfter,
+			Recursive:       true,
+			WithMetadata:    true,
+			WithVersions:    lq.Versions,
+			ReverseVersions: lq.Versions,
+		}, func(info minio.ObjectInfo) bool {
+			name := info.Key
+
+			if previous != nil && previous.Name == name {
+				previous.TimeDeleted = formatTime(info.LastModified)
+			}
+			previous = nil
+
+			if info.IsDeleteMarker {
+				return true
+			}
+
+			if skipPrefix != "" && strings.HasPrefix(name, skipPrefix) {
+				return true
+			}
+
+			if lq.seen(name, parseObjectAttrs(info).Generation) || name < lq.StartOffset {
+				return true
+			}
+
+			if lq.EndOffset != "" && name >= lq.EndOffset {
+				return false
+			}
+
+			commonPrefix := ""
+			if lq.Delimiter != "" {
+				rest := strings.TrimPrefix(name, lq.Prefix)
+				if idx := strings.Index(rest, lq.Delimiter); idx >= 0 {
+					commonPrefix = lq.Prefix + rest[:idx+len(lq.Delimiter)]
+				}
+			}
+
+			if commonPrefix != "" {
+				if commonPrefix > lq.StartAfter {
+					if count == lq.MaxResults {
+						result.NextPageToken = encodePageToken(lastToken)
+						return false
+					}
+
+					result.Prefixes = append(result.Prefixes, commonPrefix)
+					count++
+					lastToken = commonPrefix
+
+					if lq.IncludeTrailingDelimiter && name == commonPrefix && s.matchesGlob(lq, name) {
+						result.Items = append(result.Items, s.renderObject(r, bucket, info))
+					}
+				}
+
+				if lq.Versions {
+					skipPrefix = commonPrefix
+					return true
+				}
+
+				resumeAfter = commonPrefix + string(utf8.MaxRune)
+				return false
+			}
+
+			if !s.matchesGlob(lq, name) {
+				return true
+			}
+
+			if count == lq.MaxResults {
+				result.NextPageToken = encodePageToken(lastToken)
+				return false
+			}
+
+			item := s.renderObject(r, bucket, info)
+			result.Items = append(result.Items, item)
+			count++
+
+			lastToken = name
+			if lq.Versions {
+				lastToken = name + "\n" + strconv.FormatInt(item.Generation, 10)
+				previous = item
+			}
+
+			return true
+		})
+		if err != nil {
+			return nil, err
+		}
+
+		if resumeAfter == "" {
+			break
+		}
+
+		startAfter = resumeAfter
+	}
+
+	return result, nil
+}
+
+func (s *StorageService) matchesGlob(lq listQuery, name string) bool {
+	return lq.MatchGlob == nil || lq.MatchGlob.MatchString(name)
+}
+
+// Returns the storage class of an object, which objects written without one inherit from their bucket
+func (s *StorageService) objectStorageClass(bucket string, attrs objectAttrs) string {
+	if attrs.StorageClass != "" {
+		return attrs.StorageClass
+	}
+
+	return s.buckets.Get(bucket, s.defaultBucketAttrs(time.Time{})).StorageClass
+}
+
+// Renders a storage#object from either a HEAD response or a metadata listing entry
+func (s *StorageService) renderObject(r *http.Request, bucket string, info minio.ObjectInfo) *Object {
+	attrs := parseObjectAttrs(info)
+	parent := s.buckets.Get(bucket, s.defaultBucketAttrs(time.Time{}))
+
+	storageClass := attrs.StorageClass
+	if storageClass == "" {
+		storageClass = parent.StorageClass
+	}
+
+	object := &Object{
+		Kind:               "storage#object",
+		ID:                 bucket + "/" + info.Key + "/" + strconv.FormatInt(attrs.Generation, 10),
+		SelfLink:           objectSelfLink(r, bucket, info.Key),
+		MediaLink:          objectMediaLink(r, bucket, info.Key, attrs.Generation),
+		Name:               info.Key,
+		Bucket:             bucket,
+		Generation:         attrs.Generation,
+		Metageneration:     attrs.Metageneration,
+		ContentType:        attrs.ContentType,
+		ContentEncoding:    attrs.ContentEncoding,
+		ContentDisposition: attrs.ContentDisposition,
+		ContentLanguage:    attrs.ContentLanguage,
+		CacheControl:       attrs.CacheControl,
+		StorageClass:       storageClass,
+		Size:               info.Size,
+		Md5Hash:            attrs.MD5,
+		Crc32c:             attrs.CRC32C,
+		ComponentCount:     attrs.ComponentCount,
+		Etag:               strings.Trim(info.ETag, `"`),
+		TimeCreated:        formatTime(time.UnixMicro(attrs.Generation)),
+		Updated:            formatTime(attrs.Updated),
+		CustomTime:         formatOptionalTime(attrs.CustomTime),
+		Metadata:           attrs.Metadata,
+
+		RetentionExpirationTime: formatOptionalTime(retentionExpiration(parent, attrs)),
+		KmsKeyName:              attrs.KMSKeyName,
+
+		SoftDeleteTime: formatOptionalTime(attrs.SoftDeleted),
+		HardDeleteTime: formatOptionalTime(attrs.HardDeleted),
+	}
+
+	if attrs.KeySHA256 != "" {
+		object.CustomerEncryption = &CustomerEncryption{
+			EncryptionAlgorithm: encryptionAlgorithm,
+			KeySha256:           attrs.KeySHA256,
+		}
+	}
+
+	if attrs.TemporaryHold {
+		object.TemporaryHold = &attrs.TemporaryHold
+	}
+	if attrs.EventBasedHold {
+		object.EventBasedHold = &attrs.EventBasedHold
+	}
+	if attrs.RetentionMode != "" {
+		object.Retention = &ObjectRetention{
+			Mode:            attrs.RetentionMode,
+			RetainUntilTime: formatTime(attrs.RetainUntil),
+		}
+	}
+
+	if r.URL.Query().Get("projection") == "full" && !parent.UniformBucketLevelAccess {
+		target := aclTarget{
+			key:  aclKey{Kind: objectACL, Bucket: bucket, Object: info.Key, Generation: attrs.Generation},
+			etag: metagenerationEtag(attrs.Metageneration),
+		}
+		object.Acl = renderAccessControls(r, target, s.acls.Get(target.key))
+	}
+
+	return object
+}
+
+// Single part S3 uploads use the hex MD5 of the content as ETag, GCS wants it base64 encoded
+func etagToMD5(etag string) string {
+	sum, err := hex.DecodeString(strings.Trim(etag, `"`))
+	if err != nil || len(sum) != 16 {
+		return ""
+	}
+
+	return base64.StdEncoding.EncodeToString(sum)
+}
+
+func (s *StorageService) handleListObjects(w http.ResponseWriter, r *http.Request) {
+	lq, err := parseListQuery(r)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	list := s.listObjects
+	if r.URL.Query().Get("softDeleted") == "true" {
+		list = s.listSoftDeleted
+	}
+
+	result, err := list(r.Context(), r, r.PathValue("bucket"), lq)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, result)
+}
+
+func (s *StorageService) handleGetObject(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Query().Get("alt") == "media" {
+		s.handleDownloadObject(w, r)
+		return
+	}
+
+	bucket := r.PathValue("bucket")
+
+	conds, err := parsePreconditions(r.URL.Query())
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if r.URL.Query().Get("softDeleted") == "true" {
+		info, err := s.statSoftDeleted(r.Context(), bucket, r.PathValue("object"), r.URL.Query().Get("generation"))
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		writeJSON(w, http.StatusOK, s.renderSoftDeleted(r, bucket, info))
+		return
+	}
+
+	info, err := s.statGeneration(r.Context(), bucket, r.PathValue("object"), r.URL.Query().Get("generation"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if err := conds.check(true, parseObjectAttrs(info)); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, s.renderObject(r, bucket, info))
+}
+
+// Applies a PATCH body to an object's attributes. Fields set to null are
+// cleared, and custom metadata keys set to null are removed.
+func applyObjectPatch(attrs *objectAttrs, patch map[string]json.RawMessage) error {
+	fields := map[string]*string{
+		"contentType":        &attrs.ContentType,
+		"contentEncoding":    &attrs.ContentEncoding,
+		"contentDisposition": &attrs.ContentDisposition,
+		"contentLanguage":    &attrs.ContentLanguage,
+		"cacheControl":       &attrs.CacheControl,
+	}
+
+	for field, target := range fields {
+		raw, ok := patch[field]
+		if !ok {
+			continue
+		}
+
+		*target = ""
+		if err := json.Unmarshal(raw, target); err != nil {
+			return errBadRequest("Invalid value for %s: %s", field, raw)
+		}
+	}
+
+	for field, target := range map[string]*bool{
+		"temporaryHold":  &attrs.TemporaryHold,
+		"eventBasedHold": &attrs.EventBasedHold,
+	} {
+		raw, ok := patch[field]
+		if !ok {
+			continue
+		}
+
+		*target = false
+		if err := json.Unmarshal(raw, target); err != nil {
+			return errBadRequest("Invalid value for %s: %s", field, raw)
+		}
+	}
+
+	if raw, ok := patch["metadata"]; ok {
+		var metadata map[string]*string
+		if err := json.Unmarshal(raw, &metadata); err != nil {
+			return errBadRequest("Invalid value for metadata: %s", raw)
+		}
+
+		if metadata == nil {
+			attrs.Metadata = nil
+		}
+
+		for key, value := range metadata {
+			if attrs.Metadata == nil {
+				attrs.Metadata = make(map[string]string)
+			}
+
+			if value == nil {
+				delete(attrs.Metadata, key)
+			} else {
+				attrs.Metadata[key] = *value
+			}
+		}
+	}
+
+	if raw, ok := patch["customTime"]; ok {
+		var customTime string
+		if err := json.Unmarshal(raw, &customTime); err != nil {
+			return errBadRequest("Invalid value for customTime: %s", raw)
+		}
+
+		if err := attrs.setCustomTime(customTime); err != nil {
+			return err
+		}
+	}
+
+	if raw, ok := patch["retention"]; ok {
+		var retention *ObjectRetention
+		if err := json.Unmarshal(raw, &retention); err != nil {
+			return errBadRequest("Invalid value for retention: %s", raw)
+		}
+
+		if err := attrs.setRetention(retention); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Rewrites the metadata of an object's live version, bumping its
+// metageneration while the generation and data stay the same
+func (s *StorageService) updateObject(r *http.Request, update func(attrs *objectAttrs) error) (minio.ObjectInfo, error) {
+	bucket := r.PathValue("bucket")
+	name := r.PathValue("object")
+
+	conds, err := parsePreconditions(r.URL.Query())
+	if err != nil {
+		return minio.ObjectInfo{}, err
+	}
+
+	acl, err := s.parsePredefinedObjectACL(bucket, "predefinedAcl", r.URL.Query().Get("predefinedAcl"))
+	if err != nil {
+		return minio.ObjectInfo{}, err
+	}
+
+	unlock, err := s.guardObject(r.Context(), bucket, name, conds)
+	if err != nil {
+		return minio.ObjectInfo{}, err
+	}
+	defer unlock()
+
+	info, err := s.client.StatObject(r.Context(), bucket, name, minio.StatObjectOptions{Checksum: true})
+	if err != nil {
+		return minio.ObjectInfo{}, err
+	}
+
+	attrs := parseObjectAttrs(info)
+
+	if generation := r.URL.Query().Get("generation"); generation != "" && generation != strconv.FormatInt(attrs.Generation, 10) {
+		return minio.ObjectInfo{}, errBadRequest("Only the live generation of an object can be updated")
+	}
+
+	previous := attrs
+
+	if err := update(&attrs); err != nil {
+		return minio.ObjectInfo{}, err
+	}
+
+	override := r.URL.Query().Get("overrideUnlockedRetention") == "true"
+	if err := s.checkRetentionUpdate(bucket, previous, &attrs, override); err != nil {
+		return minio.ObjectInfo{}, err
+	}
+	attrs.Metageneration++
+	attrs.Updated = time.Now()
+
+	info, err = s.replaceObjectAttrs(r.Context(), bucket, name, attrs)
+	if err != nil {
+		return minio.ObjectInfo{}, err
+	}
+
+	s.storeObjectACL(bucket, info, acl)
+	s.notify(bucket, eventMetadataUpdate, info, nil)
+
+	return info, nil
+}
+
+func (s *StorageService) handlePatchObject(w http.ResponseWriter, r *http.Request) {
+	var patch map[string]json.RawMessage
+	if err := readJSON(r, &patch); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	info, err := s.updateObject(r, func(attrs *objectAttrs) error {
+		return applyObjectPatch(attrs, patch)
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, s.renderObject(r, r.PathValue("bucket"), info))
+}
+
+// Handles a full update, which replaces every writable field with the ones in the body
+func (s *StorageService) handleUpdateObject(w http.ResponseWriter, r *http.Request) {
+	var resource Object
+	if err := readJSON(r, &resource); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	info, err := s.updateObject(r, func(attrs *objectAttrs) error {
+		attrs.ContentType = resource.ContentType
+		attrs.ContentEncoding = resource.ContentEncoding
+		attrs.ContentDisposition = resource.ContentDisposition
+		attrs.ContentLanguage = resource.ContentLanguage
+		attrs.CacheControl = resource.CacheControl
+		attrs.Metadata = resource.Metadata
+		attrs.TemporaryHold = resource.TemporaryHold != nil && *resource.TemporaryHold
+		attrs.EventBasedHold = resource.EventBasedHold != nil && *resource.EventBasedHold
+
+		if err := attrs.setRetention(resource.Retention); err != nil {
+			return err
+		}
+
+		return attrs.setCustomTime(resource.CustomTime)
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, s.renderObject(r, r.PathValue("bucket"), info))
+}
+
+func (s *StorageService) handleDeleteObject(w http.ResponseWriter, r *http.Request) {
+	conds, err := parsePreconditions(r.URL.Query())
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if err := s.deleteObject(r.Context(), r.PathValue("bucket"), r.PathValue("object"), r.URL.Query().Get("generation"), conds); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// Deletes the live version of an object, or the given generation for good,
+// once its preconditions, holds and retention allow it
+func (s *StorageService) deleteObject(ctx context.Context, bucket, name, generation string, conds preconditions) error {
+	unlock := s.locks.Lock(bucket, name)
+	defer unlock()
+
+	info, err := s.statGeneration(ctx, bucket, name, generation)
+	if err != nil {
+		return err
+	}
+
+	attrs := parseObjectAttrs(info)
+
+	if err := conds.check(true, attrs); err != nil {
+		return err
+	}
+
+	if err := s.checkRetention(bucket, name, attrs); err != nil {
+		return err
+	}
+
+	// Deleting the live version leaves a noncurrent one behind in versioned
+	// buckets, while deleting a generation removes that version for good
+	opts := minio.RemoveObjectOptions{}
+	if generation != "" {
+		opts.VersionID = info.VersionID
+	}
+
+	// Versions removed for good stay restorable for as long as the bucket's soft delete policy says
+	softDeleted := generation != "" || !s.buckets.Get(bucket, s.defaultBucketAttrs(time.Time{})).Versioning
+	if softDeleted {
+		if err := s.softDelete(ctx, bucket, info); err != nil {
+			return err
+		}
+	}
+
+	if err := s.client.RemoveObject(ctx, bucket, name, opts); err != nil {
+		// The object is still live, so it mustn't be listed as soft-deleted as well
+		if softDeleted {
+			s.removeSoftDeleted(context.WithoutCancel(ctx), bucket, info)
+		}
+		return err
+	}
+
+	s.removeObjectACL(bucket, info, generation != "")
+	s.notifyRemoval(bucket, info, generation != "", nil)
+
+	return nil
+}
@@ -0,0 +1,35 @@
+package storage
+
+import (
+	"testing"
+	"unicode/utf8"
+)
+
+func TestS3StartAfter(t *testing.T) {
+	tests := []struct {
+		startAfter  string
+		startOffset string
+		want        string
+	}{
+		{"", "", ""},
+		{"a", "", "a"},
+		{"", "b", ""},
+		{"", "ab", "a"},
+		{"", "aé", "a"},
+		{"", "日本", "日"},
+		{"b", "ab", "b"},
+		{"a", "bc", "b"},
+	}
+
+	for _, tt := range tests {
+		lq := listQuery{StartAfter: tt.startAfter, StartOffset: tt.startOffset}
+
+		got := lq.s3StartAfter()
+		if got != tt.want {
+			t.Errorf("s3StartAfter() with startAfter %q and startOffset %q = %q, want %q", tt.startAfter, tt.startOffset, got, tt.want)
+		}
+		if !utf8.ValidString(got) {
+			t.Errorf("s3StartAfter() with startOffset %q = %q, which isn't valid UTF-8", tt.startOffset, got)
+		}
+	}
+}
@@ -0,0 +1,145 @@
+package storage
+
+import (
+	"context"
+	"net/url"
+	"strconv"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// Generation and metageneration preconditions, as sent in the
+// `ifGenerationMatch` family of query parameters
+type preconditions struct {
+	IfGenerationMatch        *int64
+	IfGenerationNotMatch     *int64
+	IfMetagenerationMatch    *int64
+	IfMetagenerationNotMatch *int64
+}
+
+func parsePreconditions(query url.Values) (preconditions, error) {
+	return parseConditionParams(query, "")
+}
+
+// Parses the `ifSourceGenerationMatch` family of a copy's source object
+func parseSourcePreconditions(query url.Values) (preconditions, error) {
+	return parseConditionParams(query, "Source")
+}
+
+func parseConditionParams(query url.Values, subject string) (preconditions, error) {
+	var conds preconditions
+
+	params := []struct {
+		name  string
+		value **int64
+	}{
+		{"if" + subject + "GenerationMatch", &conds.IfGenerationMatch},
+		{"if" + subject + "GenerationNotMatch", &conds.IfGenerationNotMatch},
+		{"if" + subject + "MetagenerationMatch", &conds.IfMetagenerationMatch},
+		{"if" + subject + "MetagenerationNotMatch", &conds.IfMetagenerationNotMatch},
+	}
+
+	for _, param := range params {
+		raw := query.Get(param.name)
+		if raw == "" {
+			continue
+		}
+
+		value, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return preconditions{}, errBadRequest("Invalid value for %s: %s", param.name, raw)
+		}
+		*param.value = &value
+	}
+
+	return conds, nil
+}
+
+func (p preconditions) empty() bool {
+	return p.IfGenerationMatch == nil && p.IfGenerationNotMatch == nil &&
+		p.IfMetagenerationMatch == nil && p.IfMetagenerationNotMatch == nil
+}
+
+// Checks the preconditions against the current state of an object.
+// A missing object has generation 0, which is what makes
+// `ifGenerationMatch=0` a create-if-absent guard
+func (p preconditions) check(exists bool, attrs objectAttrs) error {
+	generation := int64(0)
+	if exists {
+		generation = attrs.Generation
+	}
+
+	if p.IfGenerationMatch != nil && *p.IfGenerationMatch != generation {
+		return errPreconditionFailed()
+	}
+
+	if p.IfGenerationNotMatch != nil && *p.IfGenerationNotMatch == generation {
+		return errPreconditionFailed()
+	}
+
+	if p.IfMetagenerationMatch != nil && (!exists || *p.IfMetagenerationMatch != attrs.Metageneration) {
+		return errPreconditionFailed()
+	}
+
+	if p.IfMetagenerationNotMatch != nil && exists && *p.IfMetagenerationNotMatch == attrs.Metageneration {
+		return errPreconditionFailed()
+	}
+
+	return nil
+}
+
+// Stats an object, reporting a missing object as not existing rather than as an error
+func (s *StorageService) statObject(ctx context.Context, bucket, name string) (minio.ObjectInfo, bool, error) {
+	info, err := s.client.StatObject(ctx, bucket, name, minio.StatObjectOptions{Checksum: true})
+	if err != nil {
+		if minio.ToErrorResponse(err).Code == "NoSuchKey" {
+			return minio.ObjectInfo{}, false, nil
+		}
+		return minio.ObjectInfo{}, false, err
+	}
+
+	return info, true, nil
+}
+
+// Locks an object for writing and checks the preconditions against its
+// current state. The returned function releases the lock and must be called
+// once the guarded write has finished
+func (s *StorageService) guardObject(ctx context.Context, bucket, name string, conds preconditions) (func(), error) {
+	return s.lockObject(ctx, bucket, name, conds, false)
+}
+
+// Same as guardObject, for writes replacing the live version with a new
+// generation, which they can't while it is held or retained
+func (s *StorageService) guardOverwrite(ctx context.Context, bucket, name string, conds preconditions) (func(), error) {
+	return s.lockObject(ctx, bucket, name, conds, true)
+}
+
+func (s *StorageService) lockObject(ctx context.Context, bucket, name string, conds preconditions, overwrite bool) (func(), error) {
+	unlock := s.locks.Lock(bucket, name)
+
+	if conds.empty() && !overwrite {
+		return unlock, nil
+	}
+
+	info, exists, err := s.statObject(ctx, bucket, name)
+	if err != nil {
+		unlock()
+		return nil, err
+	}
+
+	attrs := parseObjectAttrs(info)
+
+	if err := conds.check(exists, attrs); err != nil {
+		unlock()
+		return nil, err
+	}
+
+	if overwrite && exists {
+		if err := s.checkRetention(bucket, name, attrs); err != nil {
+			unlock()
+			return nil, err
+		}
+	}
+
+	return unlock, nil
+}
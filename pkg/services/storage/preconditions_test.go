@@ -0,0 +1,77 @@
+package storage
+
+import (
+	"net/url"
+	"testing"
+)
+
+func TestParsePreconditions(t *testing.T) {
+	conds, err := parsePreconditions(url.Values{
+		"ifGenerationMatch":        {"0"},
+		"ifMetagenerationNotMatch": {"3"},
+		"ifSourceGenerationMatch":  {"7"},
+	})
+	if err != nil {
+		t.Fatalf("parsePreconditions failed: %v", err)
+	}
+
+	if conds.IfGenerationMatch == nil || *conds.IfGenerationMatch != 0 {
+		t.Errorf("IfGenerationMatch = %v, want 0", conds.IfGenerationMatch)
+	}
+	if conds.IfMetagenerationNotMatch == nil || *conds.IfMetagenerationNotMatch != 3 {
+		t.Errorf("IfMetagenerationNotMatch = %v, want 3", conds.IfMetagenerationNotMatch)
+	}
+	if conds.IfGenerationNotMatch != nil || conds.IfMetagenerationMatch != nil {
+		t.Errorf("unset preconditions were parsed: %+v", conds)
+	}
+
+	source, err := parseSourcePreconditions(url.Values{"ifSourceGenerationMatch": {"7"}, "ifGenerationMatch": {"1"}})
+	if err != nil {
+		t.Fatalf("parseSourcePreconditions failed: %v", err)
+	}
+	if source.IfGenerationMatch == nil || *source.IfGenerationMatch != 7 {
+		t.Errorf("source IfGenerationMatch = %v, want 7", source.IfGenerationMatch)
+	}
+
+	if _, err := parsePreconditions(url.Values{"ifGenerationMatch": {"abc"}}); err == nil {
+		t.Error("parsePreconditions accepted a non-numeric generation")
+	}
+}
+
+func TestPreconditionsCheck(t *testing.T) {
+	value := func(v int64) *int64 { return &v }
+	live := objectAttrs{Generation: 100, Metageneration: 2}
+
+	tests := []struct {
+		name   string
+		conds  preconditions
+		exists bool
+		ok     bool
+	}{
+		{"none", preconditions{}, true, true},
+		{"generation matches", preconditions{IfGenerationMatch: value(100)}, true, true},
+		{"generation differs", preconditions{IfGenerationMatch: value(99)}, true, false},
+		{"create if absent on missing object", preconditions{IfGenerationMatch: value(0)}, false, true},
+		{"create if absent on existing object", preconditions{IfGenerationMatch: value(0)}, true, false},
+		{"generation not match", preconditions{IfGenerationNotMatch: value(100)}, true, false},
+		{"generation not match other", preconditions{IfGenerationNotMatch: value(1)}, true, true},
+		{"generation not match zero on missing object", preconditions{IfGenerationNotMatch: value(0)}, false, false},
+		{"metageneration without generation matches", preconditions{IfMetagenerationMatch: value(2)}, true, true},
+		{"metageneration without generation differs", preconditions{IfMetagenerationMatch: value(1)}, true, false},
+		{"metageneration on missing object", preconditions{IfMetagenerationMatch: value(1)}, false, false},
+		{"metageneration not match", preconditions{IfMetagenerationNotMatch: value(2)}, true, false},
+		{"metageneration not match on missing object", preconditions{IfMetagenerationNotMatch: value(2)}, false, true},
+		{"generation and metageneration", preconditions{IfGenerationMatch: value(100), IfMetagenerationMatch: value(2)}, true, true},
+		{"generation matches but metageneration differs", preconditions{IfGenerationMatch: value(100), IfMetagenerationMatch: value(3)}, true, false},
+	}
+
+	for _, tt := range tests {
+		err := tt.conds.check(tt.exists, live)
+		if (err == nil) != tt.ok {
+			t.Errorf("%s: check() = %v, want ok %v", tt.name, err, tt.ok)
+		}
+		if err != nil && err.(*apiError).Code != 412 {
+			t.Errorf("%s: check() failed with %v, want 412", tt.name, err)
+		}
+	}
+}
@@ -0,0 +1,105 @@
+package storage
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+
+	"go.uber.org/zap"
+)
+
+// Prefix GCS puts on the Pub/Sub topic names of notification configs
+const pubsubTopicPrefix = "//pubsub.googleapis.com/"
+
+// How many messages may wait to be published before new ones are dropped
+const pubsubQueueSize = 1024
+
+// A message in the shape of the Pub/Sub REST API
+type pubsubMessage struct {
+	// Base64 encoded payload
+	Data       string            `json:"data,omitempty"`
+	Attributes map[string]string `json:"attributes,omitempty"`
+}
+
+type pubsubPublish struct {
+	// Topic name in the `projects/{project}/topics/{topic}` form
+	topic   string
+	message pubsubMessage
+}
+
+// Publishes messages through the Pub/Sub REST API in the order they were
+// queued, so that writes don't wait on the Pub/Sub endpoint
+type pubsubPublisher struct {
+	endpoint string
+	client   *http.Client
+	queue    chan pubsubPublish
+	logger   *zap.Logger
+}
+
+func newPubSubPublisher(endpoint string, logger *zap.Logger) *pubsubPublisher {
+	return &pubsubPublisher{
+		endpoint: strings.TrimSuffix(endpoint, "/"),
+		client:   &http.Client{Timeout: 10 * time.Second},
+		queue:    make(chan pubsubPublish, pubsubQueueSize),
+		logger:   logger,
+	}
+}
+
+// Queues a message for the topic, given with or without its `//pubsub.googleapis.com/` prefix
+func (p *pubsubPublisher) Publish(topic string, message pubsubMessage) {
+	if p.endpoint == "" {
+		p.logger.Debug("Dropping Pub/Sub message, no Pub/Sub endpoint is configured", zap.String("topic", topic))
+		return
+	}
+
+	select {
+	case p.queue <- pubsubPublish{topic: strings.TrimPrefix(topic, pubsubTopicPrefix), message: message}:
+	default:
+		p.logger.Warn("Dropping Pub/Sub message, the publish queue is full", zap.String("topic", topic))
+	}
+}
+
+// Publishes queued messages until ctx is done
+func (p *pubsubPublisher) Run(ctx context.Context) {
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case publish := <-p.queue:
+			if err := p.send(ctx, publish); err != nil {
+				p.logger.Warn("Failed to publish Pub/Sub message", zap.String("topic", publish.topic), zap.Error(err))
+			}
+		}
+	}
+}
+
+func (p *pubsubPublisher) send(ctx context.Context, publish pubsubPublish) error {
+	body, err := json.Marshal(map[string][]pubsubMessage{"messages": {publish.message}})
+	if err != nil {
+		return fmt.Errorf("failed to encode message: %w", err)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, p.endpoint+"/v1/"+publish.topic+":publish", bytes.NewReader(body))
+	if err != nil {
+		return fmt.Errorf("failed to create publish request: %w", err)
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to send publish request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return fmt.Errorf("publish failed with status %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+
+	return nil
+}
@@ -0,0 +1,367 @@
+package storage
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+const timeFormat = "2006-01-02T15:04:05.000Z07:00"
+
+// Number of the project every bucket belongs to
+const projectNumber = "0"
+
+type Bucket struct {
+	Kind           string            `json:"kind"`
+	ID             string            `json:"id"`
+	SelfLink       string            `json:"selfLink"`
+	ProjectNumber  string            `json:"projectNumber"`
+	Name           string            `json:"name"`
+	TimeCreated    string            `json:"timeCreated"`
+	Updated        string            `json:"updated"`
+	Metageneration int64             `json:"metageneration,string"`
+	Location       string            `json:"location"`
+	LocationType   string            `json:"locationType"`
+	StorageClass   string            `json:"storageClass"`
+	Etag           string            `json:"etag"`
+	Labels         map[string]string `json:"labels,omitempty"`
+	Versioning     *BucketVersioning `json:"versioning,omitempty"`
+
+	IamConfiguration *BucketIamConfiguration `json:"iamConfiguration,omitempty"`
+	Acl              []*AccessControl        `json:"acl,omitempty"`
+	DefaultObjectAcl []*AccessControl        `json:"defaultObjectAcl,omitempty"`
+	Lifecycle        *BucketLifecycle        `json:"lifecycle,omitempty"`
+	Cors             []BucketCors            `json:"cors,omitempty"`
+
+	RetentionPolicy       *BucketRetentionPolicy `json:"retentionPolicy,omitempty"`
+	DefaultEventBasedHold bool                   `json:"defaultEventBasedHold,omitempty"`
+	ObjectRetention       *BucketObjectRetention `json:"objectRetention,omitempty"`
+
+	Encryption *BucketEncryption `json:"encryption,omitempty"`
+
+	SoftDeletePolicy *BucketSoftDeletePolicy `json:"softDeletePolicy,omitempty"`
+}
+
+type BucketVersioning struct {
+	Enabled bool `json:"enabled"`
+}
+
+type BucketIamConfiguration struct {
+	UniformBucketLevelAccess *UniformBucketLevelAccess `json:"uniformBucketLevelAccess,omitempty"`
+	// Former name of uniform bucket-level access, still sent by older clients
+	BucketPolicyOnly *UniformBucketLevelAccess `json:"bucketPolicyOnly,omitempty"`
+}
+
+type UniformBucketLevelAccess struct {
+	Enabled    bool   `json:"enabled"`
+	LockedTime string `json:"lockedTime,omitempty"`
+}
+
+// A CORS rule, allowing the listed origins to use the listed methods
+type BucketCors struct {
+	Origin         []string `json:"origin,omitempty"`
+	Method         []string `json:"method,omitempty"`
+	ResponseHeader []string `json:"responseHeader,omitempty"`
+	MaxAgeSeconds  int64    `json:"maxAgeSeconds,omitempty"`
+}
+
+// Minimum time objects have to be kept for before they can be deleted or overwritten
+type BucketRetentionPolicy struct {
+	RetentionPeriod int64  `json:"retentionPeriod,string"`
+	EffectiveTime   string `json:"effectiveTime,omitempty"`
+	IsLocked        bool   `json:"isLocked,omitempty"`
+}
+
+type BucketObjectRetention struct {
+	Mode string `json:"mode"`
+}
+
+type BucketSoftDeletePolicy struct {
+	RetentionDurationSeconds int64  `json:"retentionDurationSeconds,string"`
+	EffectiveTime            string `json:"effectiveTime,omitempty"`
+}
+
+type BucketEncryption struct {
+	DefaultKmsKeyName string `json:"defaultKmsKeyName,omitempty"`
+}
+
+// A bucket's notification config. Unlike other resources, its fields are snake_case.
+type Notification struct {
+	Kind             string            `json:"kind"`
+	ID               string            `json:"id"`
+	SelfLink         string            `json:"selfLink"`
+	Topic            string            `json:"topic"`
+	EventTypes       []string          `json:"event_types,omitempty"`
+	CustomAttributes map[string]string `json:"custom_attributes,omitempty"`
+	PayloadFormat    string            `json:"payload_format"`
+	ObjectNamePrefix string            `json:"object_name_prefix,omitempty"`
+	Etag             string            `json:"etag"`
+}
+
+type Notifications struct {
+	Kind  string          `json:"kind"`
+	Items []*Notification `json:"items"`
+}
+
+type BucketLifecycle struct {
+	Rule []LifecycleRule `json:"rule,omitempty"`
+}
+
+type LifecycleRule struct {
+	Action    *LifecycleAction    `json:"action"`
+	Condition *LifecycleCondition `json:"condition"`
+}
+
+type LifecycleAction struct {
+	Type         string `json:"type"`
+	StorageClass string `json:"storageClass,omitempty"`
+}
+
+// Conditions of a lifecycle rule, all of which have to hold for its action to apply
+type LifecycleCondition struct {
+	// Zero is a valid age, matching every object
+	Age                 *int64   `json:"age,omitempty"`
+	CreatedBefore       string   `json:"createdBefore,omitempty"`
+	DaysSinceCustomTime int64    `json:"daysSinceCustomTime,omitempty"`
+	IsLive              *bool    `json:"isLive,omitempty"`
+	NumNewerVersions    int64    `json:"numNewerVersions,omitempty"`
+	MatchesPrefix       []string `json:"matchesPrefix,omitempty"`
+	MatchesSuffix       []string `json:"matchesSuffix,omitempty"`
+	MatchesStorageClass []string `json:"matchesStorageClass,omitempty"`
+}
+
+// A legacy ACL entry of a bucket, or of an object or a bucket's default object ACL
+type AccessControl struct {
+	Kind        string       `json:"kind"`
+	ID          string       `json:"id"`
+	SelfLink    string       `json:"selfLink"`
+	Bucket      string       `json:"bucket"`
+	Object      string       `json:"object,omitempty"`
+	Generation  int64        `json:"generation,string,omitempty"`
+	Entity      string       `json:"entity"`
+	Role        string       `json:"role"`
+	Email       string       `json:"email,omitempty"`
+	Domain      string       `json:"domain,omitempty"`
+	ProjectTeam *ProjectTeam `json:"projectTeam,omitempty"`
+	Etag        string       `json:"etag"`
+}
+
+type ProjectTeam struct {
+	ProjectNumber string `json:"projectNumber"`
+	Team          string `json:"team"`
+}
+
+type AccessControls struct {
+	Kind  string           `json:"kind"`
+	Items []*AccessControl `json:"items"`
+}
+
+type Policy struct {
+	Kind       string          `json:"kind"`
+	ResourceID string          `json:"resourceId"`
+	Version    int             `json:"version"`
+	Etag       string          `json:"etag"`
+	Bindings   []PolicyBinding `json:"bindings"`
+}
+
+type PolicyBinding struct {
+	Role      string           `json:"role"`
+	Members   []string         `json:"members"`
+	Condition *PolicyCondition `json:"condition,omitempty"`
+}
+
+type PolicyCondition struct {
+	Title       string `json:"title,omitempty"`
+	Description string `json:"description,omitempty"`
+	Expression  string `json:"expression"`
+}
+
+type TestIamPermissionsResponse struct {
+	Kind        string   `json:"kind"`
+	Permissions []string `json:"permissions,omitempty"`
+}
+
+// An HMAC key's metadata. Its secret is only returned once, when the key is created.
+type HmacKeyMetadata struct {
+	Kind                string `json:"kind"`
+	ID                  string `json:"id"`
+	SelfLink            string `json:"selfLink"`
+	AccessID            string `json:"accessId"`
+	ProjectID           string `json:"projectId"`
+	ServiceAccountEmail string `json:"serviceAccountEmail"`
+	State               string `json:"state"`
+	TimeCreated         string `json:"timeCreated"`
+	Updated             string `json:"updated"`
+	Etag                string `json:"etag"`
+}
+
+type HmacKey struct {
+	Kind     string           `json:"kind"`
+	Metadata *HmacKeyMetadata `json:"metadata"`
+	Secret   string           `json:"secret"`
+}
+
+type HmacKeysMetadata struct {
+	Kind          string             `json:"kind"`
+	Items         []*HmacKeyMetadata `json:"items"`
+	NextPageToken string             `json:"nextPageToken,omitempty"`
+}
+
+type Buckets struct {
+	Kind          string    `json:"kind"`
+	Items         []*Bucket `json:"items"`
+	NextPageToken string    `json:"nextPageToken,omitempty"`
+}
+
+func formatTime(t time.Time) string {
+	return t.UTC().Format(timeFormat)
+}
+
+// Formats a time that is left out of resources while unset
+func formatOptionalTime(t time.Time) string {
+	if t.IsZero() {
+		return ""
+	}
+
+	return formatTime(t)
+}
+
+// GCS etags are opaque, so derive a stable one from the resource's metageneration
+func metagenerationEtag(metageneration int64) string {
+	return base64.StdEncoding.EncodeToString([]byte("CA" + strconv.FormatInt(metageneration, 10)))
+}
+
+// Builds the scheme://host prefix used in selfLink and mediaLink fields
+func baseURL(r *http.Request) string {
+	scheme := "http"
+	if r.TLS != nil {
+		scheme = "https"
+	}
+
+	if proto := r.Header.Get("X-Forwarded-Proto"); proto != "" {
+		scheme = proto
+	}
+
+	return scheme + "://" + r.Host
+}
+
+func bucketSelfLink(r *http.Request, bucket string) string {
+	return baseURL(r) + "/storage/v1/b/" + url.PathEscape(bucket)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json; charset=UTF-8")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func readJSON(r *http.Request, v any) error {
+	if r.Body == nil || r.ContentLength == 0 {
+		return nil
+	}
+
+	if err := json.NewDecoder(r.Body).Decode(v); err != nil && !errors.Is(err, io.EOF) {
+		return errBadRequest("Failed to parse request body: %v", err)
+	}
+
+	return nil
+}
+
+// Page tokens are opaque to clients, so the last returned name is simply base64 encoded
+func encodePageToken(last string) string {
+	return base64.RawURLEncoding.EncodeToString([]byte(last))
+}
+
+func decodePageToken(token string) (string, error) {
+	if token == "" {
+		return "", nil
+	}
+
+	decoded, err := base64.RawURLEncoding.DecodeString(token)
+	if err != nil {
+		return "", errBadRequest("Invalid pageToken: %s", token)
+	}
+
+	return string(decoded), nil
+}
+
+type Object struct {
+	Kind               string            `json:"kind"`
+	ID                 string            `json:"id"`
+	SelfLink           string            `json:"selfLink"`
+	MediaLink          string            `json:"mediaLink"`
+	Name               string            `json:"name"`
+	Bucket             string            `json:"bucket"`
+	Generation         int64             `json:"generation,string"`
+	Metageneration     int64             `json:"metageneration,string"`
+	ContentType        string            `json:"contentType,omitempty"`
+	ContentEncoding    string            `json:"contentEncoding,omitempty"`
+	ContentDisposition string            `json:"contentDisposition,omitempty"`
+	ContentLanguage    string            `json:"contentLanguage,omitempty"`
+	CacheControl       string            `json:"cacheControl,omitempty"`
+	StorageClass       string            `json:"storageClass"`
+	Size               int64             `json:"size,string"`
+	Md5Hash            string            `json:"md5Hash,omitempty"`
+	Crc32c             string            `json:"crc32c,omitempty"`
+	ComponentCount     int               `json:"componentCount,omitempty"`
+	Etag               string            `json:"etag"`
+	TimeCreated        string            `json:"timeCreated"`
+	Updated            string            `json:"updated"`
+	TimeDeleted        string            `json:"timeDeleted,omitempty"`
+	CustomTime         string            `json:"customTime,omitempty"`
+	Metadata           map[string]string `json:"metadata,omitempty"`
+	Acl                []*AccessControl  `json:"acl,omitempty"`
+
+	TemporaryHold           *bool            `json:"temporaryHold,omitempty"`
+	EventBasedHold          *bool            `json:"eventBasedHold,omitempty"`
+	RetentionExpirationTime string           `json:"retentionExpirationTime,omitempty"`
+	Retention               *ObjectRetention `json:"retention,omitempty"`
+
+	CustomerEncryption *CustomerEncryption `json:"customerEncryption,omitempty"`
+	KmsKeyName         string              `json:"kmsKeyName,omitempty"`
+
+	SoftDeleteTime string `json:"softDeleteTime,omitempty"`
+	HardDeleteTime string `json:"hardDeleteTime,omitempty"`
+}
+
+// Describes the customer-supplied key an object is encrypted with
+type CustomerEncryption struct {
+	EncryptionAlgorithm string `json:"encryptionAlgorithm"`
+	KeySha256           string `json:"keySha256"`
+}
+
+// An object's own retention configuration
+type ObjectRetention struct {
+	Mode            string `json:"mode"`
+	RetainUntilTime string `json:"retainUntilTime"`
+}
+
+type RewriteResponse struct {
+	Kind                string  `json:"kind"`
+	TotalBytesRewritten int64   `json:"totalBytesRewritten,string"`
+	ObjectSize          int64   `json:"objectSize,string"`
+	Done                bool    `json:"done"`
+	RewriteToken        string  `json:"rewriteToken,omitempty"`
+	Resource            *Object `json:"resource,omitempty"`
+}
+
+type Objects struct {
+	Kind          string    `json:"kind"`
+	Items         []*Object `json:"items"`
+	Prefixes      []string  `json:"prefixes,omitempty"`
+	NextPageToken string    `json:"nextPageToken,omitempty"`
+}
+
+func objectSelfLink(r *http.Request, bucket, name string) string {
+	return bucketSelfLink(r, bucket) + "/o/" + url.PathEscape(name)
+}
+
+func objectMediaLink(r *http.Request, bucket, name string, generation int64) string {
+	return baseURL(r) + "/download/storage/v1/b/" + url.PathEscape(bucket) + "/o/" + url.PathEscape(name) +
+		"?generation=" + strconv.FormatInt(generation, 10) + "&alt=media"
+}
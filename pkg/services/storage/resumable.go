@@ -0,0 +1,575 @@
+package storage
+
+import (
+	"context"
+	"crypto/md5"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"go.uber.org/zap"
+)
+
+// Smallest part S3 accepts for anything but the last part of a multipart upload.
+// GCS chunks only need to be multiples of 256KiB, so smaller chunks are buffered until this is reached.
+const minPartSize = 5 << 20
+
+// statusResumeIncomplete is GCS's "308 Resume Incomplete", returned for every non-final chunk
+const statusResumeIncomplete = http.StatusPermanentRedirect
+
+// statusClientClosedRequest is what GCS answers a cancelled upload session with
+const statusClientClosedRequest = 499
+
+var contentRangePattern = regexp.MustCompile(`^bytes (\*|(\d+)-(\d+))/(\*|\d+)$`)
+
+// An in-progress resumable upload, backed by an S3 multipart upload in MinIO
+type uploadSession struct {
+	ID       string
+	Bucket   string
+	Name     string
+	Attrs    objectAttrs
+	UploadID string
+
+	// Checked again when the upload is finalized, since the object may have changed meanwhile
+	Conditions preconditions
+	// Predefined ACL the object gets once finalized, nil for the bucket's default
+	ACL       []aclEntry
+	ExpiresAt time.Time
+
+	// Total object size, or -1 until the client declares it
+	Total int64
+	// Bytes received so far, including those still pending
+	Received int64
+
+	expectedMD5    string
+	expectedCRC32C string
+
+	// Customer-supplied key the data is encrypted with as it arrives, nil to store it in the clear
+	key *customerKey
+
+	parts       []minio.CompletePart
+	pending     *os.File
+	pendingSize int64
+	md5         hash.Hash
+	crc32c      hash.Hash32
+
+	// Set once the upload has been finalized, so that retried final chunks get the same answer
+	result *minio.ObjectInfo
+
+	mu sync.Mutex
+}
+
+// Write appends chunk data to the pending part buffer, encrypting it when the session has a key
+func (us *uploadSession) Write(p []byte) (int, error) {
+	data := p
+	if us.key != nil {
+		data = make([]byte, len(p))
+		us.key.stream(us.Attrs.EncryptionIV, us.Received).XORKeyStream(data, p)
+	}
+
+	n, err := us.pending.Write(data)
+	us.md5.Write(p[:n])
+	us.crc32c.Write(p[:n])
+	us.pendingSize += int64(n)
+	us.Received += int64(n)
+
+	return n, err
+}
+
+func (us *uploadSession) cleanup() {
+	if us.pending != nil {
+		us.pending.Close()
+		os.Remove(us.pending.Name())
+		us.pending = nil
+	}
+}
+
+// Tracks resumable upload sessions across requests until they complete or expire
+type uploadSessionManager struct {
+	sessions map[string]*uploadSession
+	ttl      time.Duration
+	mu       sync.Mutex
+}
+
+func newUploadSessionManager(ttl time.Duration) *uploadSessionManager {
+	return &uploadSessionManager{
+		sessions: make(map[string]*uploadSession),
+		ttl:      ttl,
+	}
+}
+
+func (m *uploadSessionManager) Add(session *uploadSession) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	session.ExpiresAt = time.Now().Add(m.ttl)
+	m.sessions[session.ID] = session
+}
+
+func (m *uploadSessionManager) Get(id string) (*uploadSession, bool) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	session, exists := m.sessions[id]
+	if !exists || time.Now().After(session.ExpiresAt) {
+		return nil, false
+	}
+
+	return session, true
+}
+
+func (m *uploadSessionManager) Remove(id string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	delete(m.sessions, id)
+}
+
+// Reports whether a MinIO multipart upload backs one of the sessions
+func (m *uploadSessionManager) HasUpload(uploadID string) bool {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, session := range m.sessions {
+		if session.UploadID == uploadID {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Removes and returns all sessions past their expiry
+func (m *uploadSessionManager) TakeExpired(now time.Time) []*uploadSession {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var expired []*uploadSession
+	for id, session := range m.sessions {
+		if now.After(session.ExpiresAt) {
+			expired = append(expired, session)
+			delete(m.sessions, id)
+		}
+	}
+
+	return expired
+}
+
+// Periodically aborts expired sessions and their MinIO multipart uploads
+func (s *StorageService) expireUploadSessions(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			for _, session := range s.uploads.TakeExpired(now) {
+				s.abortUploadSession(ctx, session)
+			}
+		}
+	}
+}
+
+func (s *StorageService) abortUploadSession(ctx context.Context, session *uploadSession) {
+	session.mu.Lock()
+	defer session.mu.Unlock()
+
+	if session.result != nil {
+		session.cleanup()
+		return
+	}
+
+	s.abortUploadSessionLocked(ctx, session)
+}
+
+func newSessionID() (string, error) {
+	buf := make([]byte, 16)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+
+	return hex.EncodeToString(buf), nil
+}
+
+// Handles `uploadType=resumable` by opening a session and returning its URI in the Location header
+func (s *StorageService) startResumableUpload(w http.ResponseWriter, r *http.Request) {
+	bucket := r.PathValue("bucket")
+
+	var resource Object
+	if err := readJSON(r, &resource); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if resource.Name == "" {
+		resource.Name = r.URL.Query().Get("name")
+	}
+	if resource.Name == "" {
+		writeError(w, errBadRequest("Required parameter: name"))
+		return
+	}
+	if resource.KmsKeyName == "" {
+		resource.KmsKeyName = r.URL.Query().Get("kmsKeyName")
+	}
+
+	conds, err := parsePreconditions(r.URL.Query())
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	acl, err := s.parsePredefinedObjectACL(bucket, "predefinedAcl", r.URL.Query().Get("predefinedAcl"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	key, err := parseCustomerKey(r.Header, encryptionHeaderPrefix)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	if !conds.empty() {
+		info, exists, err := s.statObject(r.Context(), bucket, resource.Name)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+
+		if err := conds.check(exists, parseObjectAttrs(info)); err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+
+	attrs, err := attrsFromResource(&resource)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if attrs.ContentType == "" {
+		attrs.ContentType = r.Header.Get("X-Upload-Content-Type")
+	}
+	if attrs.ContentType == "" {
+		attrs.ContentType = "application/octet-stream"
+	}
+
+	if err := s.applyBucketRetention(bucket, &attrs); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	// The stream is recreated at each chunk's offset, since chunks may be resent
+	if _, err := s.setupEncryption(bucket, &attrs, key); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	total := int64(-1)
+	if length := r.Header.Get("X-Upload-Content-Length"); length != "" {
+		parsed, err := strconv.ParseInt(length, 10, 64)
+		if err != nil || parsed < 0 {
+			writeError(w, errBadRequest("Invalid X-Upload-Content-Length: %s", length))
+			return
+		}
+		total = parsed
+	}
+
+	id, err := newSessionID()
+	if err != nil {
+		writeError(w, fmt.Errorf("failed to generate upload session id: %w", err))
+		return
+	}
+
+	uploadID, err := s.core.NewMultipartUpload(r.Context(), bucket, resource.Name, attrs.putOptions())
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	pending, err := os.CreateTemp("", "glocal-resumable-*")
+	if err != nil {
+		_ = s.core.AbortMultipartUpload(r.Context(), bucket, resource.Name, uploadID)
+		writeError(w, fmt.Errorf("failed to create upload buffer: %w", err))
+		return
+	}
+
+	s.uploads.Add(&uploadSession{
+		ID:             id,
+		Bucket:         bucket,
+		Name:           resource.Name,
+		Attrs:          attrs,
+		UploadID:       uploadID,
+		Conditions:     conds,
+		ACL:            acl,
+		Total:          total,
+		expectedMD5:    resource.Md5Hash,
+		expectedCRC32C: resource.Crc32c,
+		key:            key,
+		pending:        pending,
+		md5:            md5.New(),
+		crc32c:         crc32.New(crc32cTable),
+	})
+
+	location := baseURL(r) + "/upload/storage/v1/b/" + url.PathEscape(bucket) +
+		"/o?uploadType=resumable&upload_id=" + id
+
+	w.Header().Set("Location", location)
+	w.Header().Set("X-GUploader-UploadID", id)
+	w.WriteHeader(http.StatusOK)
+}
+
+// Parses a chunk's Content-Range. start is -1 for status queries, total is -1 while unknown.
+func parseContentRange(header string) (start, end, total int64, err error) {
+	match := contentRangePattern.FindStringSubmatch(header)
+	if match == nil {
+		return 0, 0, 0, errBadRequest("Invalid Content-Range: %s", header)
+	}
+
+	start, end, total = -1, -1, -1
+
+	if match[1] != "*" {
+		start, _ = strconv.ParseInt(match[2], 10, 64)
+		end, _ = strconv.ParseInt(match[3], 10, 64)
+
+		if end < start {
+			return 0, 0, 0, errBadRequest("Invalid Content-Range: %s", header)
+		}
+	}
+
+	if match[4] != "*" {
+		total, _ = strconv.ParseInt(match[4], 10, 64)
+	}
+
+	return start, end, total, nil
+}
+
+// Handles chunk uploads and status queries (PUT with `Content-Range: bytes */total`)
+func (s *StorageService) handleResumableChunk(w http.ResponseWriter, r *http.Request) {
+	session, exists := s.uploads.Get(r.URL.Query().Get("upload_id"))
+	if !exists {
+		writeError(w, errNotFound("No such upload session: %s", r.URL.Query().Get("upload_id")))
+		return
+	}
+
+	session.mu.Lock()
+	defer session.mu.Unlock()
+
+	if session.result != nil {
+		writeJSON(w, http.StatusOK, s.renderObject(r, session.Bucket, *session.result))
+		return
+	}
+
+	start, total := int64(0), int64(-1)
+
+	if header := r.Header.Get("Content-Range"); header != "" {
+		var err error
+		start, _, total, err = parseContentRange(header)
+		if err != nil {
+			writeError(w, err)
+			return
+		}
+	} else if r.ContentLength >= 0 {
+		// A single PUT without Content-Range carries the whole object
+		total = r.ContentLength
+	}
+
+	if total >= 0 {
+		if session.Total >= 0 && session.Total != total {
+			writeError(w, errBadRequest("Object size %d does not match the declared size of %d", total, session.Total))
+			return
+		}
+		session.Total = total
+	}
+
+	if start >= 0 {
+		if start > session.Received {
+			writeError(w, errBadRequest("Invalid request. The upload offset is %d bytes, which exceeds already uploaded size of %d bytes.", start, session.Received))
+			return
+		}
+
+		// Clients resuming after a lost response may resend bytes that were already persisted
+		if _, err := io.CopyN(io.Discard, r.Body, session.Received-start); err != nil {
+			writeError(w, errBadRequest("Failed to read upload data: %v", err))
+			return
+		}
+
+		if _, err := io.Copy(session, r.Body); err != nil {
+			writeError(w, errBadRequest("Failed to read upload data: %v", err))
+			return
+		}
+	}
+
+	final := session.Total >= 0 && session.Received >= session.Total
+	if session.Total >= 0 && session.Received > session.Total {
+		writeError(w, errBadRequest("Received %d bytes for an object of size %d", session.Received, session.Total))
+		return
+	}
+
+	if session.pendingSize >= minPartSize || (final && (session.pendingSize > 0 || len(session.parts) == 0)) {
+		if err := s.flushUploadPart(r.Context(), session); err != nil {
+			writeError(w, err)
+			return
+		}
+	}
+
+	if !final {
+		writeResumeIncomplete(w, r, session.Received)
+		return
+	}
+
+	info, err := s.completeUploadSession(r.Context(), session)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, s.renderObject(r, session.Bucket, info))
+}
+
+// Reports the persisted byte range of an unfinished upload. Clients that can't
+// tell GCS's 308 apart from a redirect opt into a 200 carrying an override header.
+func writeResumeIncomplete(w http.ResponseWriter, r *http.Request, received int64) {
+	if received > 0 {
+		w.Header().Set("Range", fmt.Sprintf("bytes=0-%d", received-1))
+	}
+	w.Header().Set("Content-Length", "0")
+
+	if r.Header.Get("X-GUploader-No-308") == "yes" {
+		w.Header().Set("X-Http-Status-Code-Override", "308")
+		w.WriteHeader(http.StatusOK)
+		return
+	}
+
+	w.WriteHeader(statusResumeIncomplete)
+}
+
+// Uploads the buffered chunk data as the next part of the multipart upload
+func (s *StorageService) flushUploadPart(ctx context.Context, session *uploadSession) error {
+	partNumber := len(session.parts) + 1
+
+	// A section reader keeps the HTTP client from closing the buffer file once the part is sent
+	data := io.NewSectionReader(session.pending, 0, session.pendingSize)
+
+	part, err := s.core.PutObjectPart(ctx, session.Bucket, session.Name, session.UploadID, partNumber,
+		data, session.pendingSize, minio.PutObjectPartOptions{})
+	if err != nil {
+		return err
+	}
+
+	session.parts = append(session.parts, minio.CompletePart{
+		PartNumber: partNumber,
+		ETag:       part.ETag,
+	})
+
+	if err := session.pending.Truncate(0); err != nil {
+		return fmt.Errorf("failed to reset upload buffer: %w", err)
+	}
+	if _, err := session.pending.Seek(0, io.SeekStart); err != nil {
+		return fmt.Errorf("failed to rewind upload buffer: %w", err)
+	}
+	session.pendingSize = 0
+
+	return nil
+}
+
+// Completes the multipart upload, then stamps the hashes of the received data and a generation
+// taken under the object lock onto the object. The session is removed whenever completion fails.
+func (s *StorageService) completeUploadSession(ctx context.Context, session *uploadSession) (minio.ObjectInfo, error) {
+	md5Hash := base64.StdEncoding.EncodeToString(session.md5.Sum(nil))
+	crc32c := encodeCRC32C(session.crc32c.Sum32())
+
+	fail := func(err error) (minio.ObjectInfo, error) {
+		s.uploads.Remove(session.ID)
+		s.abortUploadSessionLocked(ctx, session)
+		return minio.ObjectInfo{}, err
+	}
+
+	if (session.expectedMD5 != "" && session.expectedMD5 != md5Hash) ||
+		(session.expectedCRC32C != "" && session.expectedCRC32C != crc32c) {
+		return fail(errBadRequest("Provided hashes do not match the uploaded data (md5 %s, crc32c %s)", md5Hash, crc32c))
+	}
+
+	unlock, err := s.guardOverwrite(ctx, session.Bucket, session.Name, session.Conditions)
+	if err != nil {
+		return fail(err)
+	}
+	defer unlock()
+
+	replaced, undo, err := s.replacedVersion(ctx, session.Bucket, session.Name)
+	if err != nil {
+		return fail(err)
+	}
+
+	if _, err := s.core.CompleteMultipartUpload(ctx, session.Bucket, session.Name, session.UploadID,
+		session.parts, minio.PutObjectOptions{}); err != nil {
+		undo()
+		return fail(err)
+	}
+
+	// The multipart upload is gone from here on, only the session is left to drop on failure
+	session.cleanup()
+
+	// A multipart ETag is no MD5 and MinIO can't hash ciphertext for us, so the hashes come
+	// from the session. The generation is only taken now, so that it's newer than the one replaced.
+	attrs := session.Attrs
+	attrs.startGeneration()
+	attrs.MD5 = md5Hash
+	attrs.CRC32C = crc32c
+
+	info, err := s.replaceObjectAttrs(ctx, session.Bucket, session.Name, attrs)
+	if err != nil {
+		s.uploads.Remove(session.ID)
+		return minio.ObjectInfo{}, err
+	}
+
+	s.initObjectACL(session.Bucket, info, session.ACL)
+	s.notifyFinalize(session.Bucket, replaced, info)
+
+	session.result = &info
+
+	return info, nil
+}
+
+// Same as abortUploadSession, for callers already holding the session lock
+func (s *StorageService) abortUploadSessionLocked(ctx context.Context, session *uploadSession) {
+	session.cleanup()
+
+	if err := s.core.AbortMultipartUpload(ctx, session.Bucket, session.Name, session.UploadID); err != nil {
+		s.logger.Warn("Failed to abort multipart upload",
+			zap.String("bucket", session.Bucket),
+			zap.String("object", session.Name),
+			zap.Error(err))
+	}
+}
+
+// Handles DELETE on a session URI, which cancels the upload
+func (s *StorageService) handleCancelResumableUpload(w http.ResponseWriter, r *http.Request) {
+	id := r.URL.Query().Get("upload_id")
+
+	session, exists := s.uploads.Get(id)
+	if !exists {
+		writeError(w, errNotFound("No such upload session: %s", id))
+		return
+	}
+
+	s.uploads.Remove(id)
+	s.abortUploadSession(r.Context(), session)
+
+	w.WriteHeader(statusClientClosedRequest)
+}
@@ -0,0 +1,228 @@
+package storage
+
+import (
+	"net/http"
+	"strconv"
+	"time"
+)
+
+// Modes of an object's own retention. Locked retention can only be extended,
+// while unlocked retention can be shortened or removed with an override.
+const (
+	retentionLocked   = "Locked"
+	retentionUnlocked = "Unlocked"
+)
+
+// Longest retention period GCS accepts, 100 years in seconds
+const maxRetentionPeriod = 3155760000
+
+func errRetentionPolicyNotMet(format string, args ...any) *apiError {
+	return newAPIError(http.StatusForbidden, "retentionPolicyNotMet", format, args...)
+}
+
+// GCS doesn't allow retention policies on buckets that keep noncurrent versions
+func errVersionedRetention() *apiError {
+	return errBadRequest("Retention policies and object versioning can't be enabled on the same bucket.")
+}
+
+// Sets or, given nil or a zero period, removes the bucket's retention policy.
+// A locked policy can only have its period increased.
+func (attrs *bucketAttrs) setRetentionPolicy(policy *BucketRetentionPolicy) error {
+	period := int64(0)
+	if policy != nil {
+		period = policy.RetentionPeriod
+	}
+
+	if period < 0 || period > maxRetentionPeriod {
+		return errBadRequest("Invalid value for retentionPeriod: %d", period)
+	}
+
+	if attrs.RetentionLocked && period < attrs.RetentionPeriod {
+		return newAPIError(http.StatusForbidden, "forbidden",
+			"Cannot reduce the retention period of a locked retention policy.")
+	}
+
+	if period != attrs.RetentionPeriod {
+		attrs.RetentionEffectiveTime = time.Now()
+	}
+	if period == 0 {
+		attrs.RetentionEffectiveTime = time.Time{}
+	}
+
+	attrs.RetentionPeriod = period
+
+	return nil
+}
+
+// Returns the time the bucket's retention policy stops protecting an object,
+// or zero without a policy. The period starts over when an event-based hold is released.
+func retentionExpiration(parent bucketAttrs, attrs objectAttrs) time.Time {
+	if parent.RetentionPeriod == 0 {
+		return time.Time{}
+	}
+
+	start := time.UnixMicro(attrs.Generation)
+	if attrs.HoldReleased.After(start) {
+		start = attrs.HoldReleased
+	}
+
+	return start.Add(time.Duration(parent.RetentionPeriod) * time.Second)
+}
+
+// Rejects deleting, overwriting or archiving an object version that is held or still retained
+func (s *StorageService) checkRetention(bucket, name string, attrs objectAttrs) error {
+	now := time.Now()
+
+	switch {
+	case attrs.TemporaryHold:
+		return errRetentionPolicyNotMet("Object '%s/%s' is under active Temporary hold and cannot be deleted, overwritten or archived until hold is removed.",
+			bucket, name)
+	case attrs.EventBasedHold:
+		return errRetentionPolicyNotMet("Object '%s/%s' is under active Event-Based hold and cannot be deleted, overwritten or archived until hold is removed.",
+			bucket, name)
+	case attrs.RetainUntil.After(now):
+		return errRetentionPolicyNotMet("Object '%s/%s' is subject to object retention and cannot be deleted, overwritten or archived until %s",
+			bucket, name, formatTime(attrs.RetainUntil))
+	}
+
+	parent := s.buckets.Get(bucket, s.defaultBucketAttrs(time.Time{}))
+	if expiration := retentionExpiration(parent, attrs); expiration.After(now) {
+		return errRetentionPolicyNotMet("Object '%s/%s' is subject to bucket's retention policy and cannot be deleted, overwritten or archived until %s",
+			bucket, name, formatTime(expiration))
+	}
+
+	return nil
+}
+
+// Sets the object's own retention from its resource form, or removes it given nil
+func (a *objectAttrs) setRetention(retention *ObjectRetention) error {
+	if retention == nil {
+		a.RetentionMode = ""
+		a.RetainUntil = time.Time{}
+		return nil
+	}
+
+	if retention.Mode != retentionLocked && retention.Mode != retentionUnlocked {
+		return errBadRequest("Invalid value for retention.mode: %s", retention.Mode)
+	}
+
+	retainUntil, err := time.Parse(time.RFC3339Nano, retention.RetainUntilTime)
+	if err != nil {
+		return errBadRequest("Invalid value for retention.retainUntilTime: %s", retention.RetainUntilTime)
+	}
+
+	a.RetentionMode = retention.Mode
+	a.RetainUntil = retainUntil
+
+	return nil
+}
+
+// Checks a change of an object's own retention. Extending or locking it is
+// always allowed, while loosening unlocked retention needs an override.
+func checkObjectRetention(parent bucketAttrs, previous, attrs objectAttrs, override bool) error {
+	if attrs.RetentionMode == previous.RetentionMode && attrs.RetainUntil.Equal(previous.RetainUntil) {
+		return nil
+	}
+
+	now := time.Now()
+
+	if attrs.RetentionMode != "" {
+		if !parent.ObjectRetention {
+			return errBadRequest("Object retention is not enabled on this bucket.")
+		}
+		if !attrs.RetainUntil.After(now) {
+			return errBadRequest("The retainUntilTime of an object's retention must be in the future.")
+		}
+	}
+
+	extended := attrs.RetentionMode != "" && !attrs.RetainUntil.Before(previous.RetainUntil) &&
+		(attrs.RetentionMode == retentionLocked || previous.RetentionMode != retentionLocked)
+
+	// Expired retention no longer protects anything
+	if extended || !previous.RetainUntil.After(now) {
+		return nil
+	}
+
+	if previous.RetentionMode == retentionLocked {
+		return newAPIError(http.StatusForbidden, "forbidden",
+			"Locked object retention can't be removed or shortened before it expires.")
+	}
+
+	if !override {
+		return errBadRequest("Shortening or removing Unlocked object retention requires overrideUnlockedRetention=true.")
+	}
+
+	return nil
+}
+
+// Checks the retention a metadata update sets on an object's live version, and
+// restarts the bucket's retention period when its event-based hold is released
+func (s *StorageService) checkRetentionUpdate(bucket string, previous objectAttrs, attrs *objectAttrs, override bool) error {
+	parent := s.buckets.Get(bucket, s.defaultBucketAttrs(time.Time{}))
+	if err := checkObjectRetention(parent, previous, *attrs, override); err != nil {
+		return err
+	}
+
+	if previous.EventBasedHold && !attrs.EventBasedHold {
+		attrs.HoldReleased = time.Now()
+	}
+
+	return nil
+}
+
+// Checks the retention a new object is written with, and puts it under an
+// event-based hold if the bucket asks for one by default
+func (s *StorageService) applyBucketRetention(bucket string, attrs *objectAttrs) error {
+	parent := s.buckets.Get(bucket, s.defaultBucketAttrs(time.Time{}))
+	if err := checkObjectRetention(parent, objectAttrs{}, *attrs, false); err != nil {
+		return err
+	}
+
+	if parent.DefaultEventBasedHold {
+		attrs.EventBasedHold = true
+	}
+
+	return nil
+}
+
+// Locks the bucket's retention policy, after which it can't be removed or
+// reduced. Like GCS, the request has to name the bucket's current metageneration.
+func (s *StorageService) handleLockRetentionPolicy(w http.ResponseWriter, r *http.Request) {
+	info, err := s.lookupBucket(r.Context(), r.PathValue("bucket"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	raw := r.URL.Query().Get("ifMetagenerationMatch")
+	if raw == "" {
+		writeError(w, errBadRequest("Required parameter: ifMetagenerationMatch"))
+		return
+	}
+
+	metageneration, err := strconv.ParseInt(raw, 10, 64)
+	if err != nil {
+		writeError(w, errBadRequest("Invalid value for ifMetagenerationMatch: %s", raw))
+		return
+	}
+
+	attrs, err := s.buckets.Update(info.Name, s.defaultBucketAttrs(info.CreationDate), func(attrs *bucketAttrs) error {
+		if attrs.Metageneration != metageneration {
+			return errPreconditionFailed()
+		}
+
+		if attrs.RetentionPeriod == 0 {
+			return errBadRequest("Bucket '%s' has no retention policy to lock.", info.Name)
+		}
+
+		attrs.RetentionLocked = true
+
+		return nil
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, s.renderBucket(r, info, attrs))
+}
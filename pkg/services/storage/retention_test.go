@@ -0,0 +1,53 @@
+package storage
+
+import (
+	"testing"
+	"time"
+)
+
+func TestCheckObjectRetention(t *testing.T) {
+	now := time.Now()
+	enabled := bucketAttrs{ObjectRetention: true}
+
+	retained := func(mode string, until time.Time) objectAttrs {
+		return objectAttrs{RetentionMode: mode, RetainUntil: until}
+	}
+	later := now.Add(48 * time.Hour)
+	sooner := now.Add(24 * time.Hour)
+
+	tests := []struct {
+		name     string
+		parent   bucketAttrs
+		previous objectAttrs
+		attrs    objectAttrs
+		override bool
+		status   int
+	}{
+		{"unchanged", enabled, retained(retentionLocked, later), retained(retentionLocked, later), false, 0},
+		{"new retention", enabled, objectAttrs{}, retained(retentionUnlocked, later), false, 0},
+		{"retention disabled on bucket", bucketAttrs{}, objectAttrs{}, retained(retentionUnlocked, later), false, 400},
+		{"retain until in the past", enabled, objectAttrs{}, retained(retentionUnlocked, now.Add(-time.Hour)), false, 400},
+		{"extend unlocked", enabled, retained(retentionUnlocked, sooner), retained(retentionUnlocked, later), false, 0},
+		{"extend locked", enabled, retained(retentionLocked, sooner), retained(retentionLocked, later), false, 0},
+		{"lock unlocked", enabled, retained(retentionUnlocked, sooner), retained(retentionLocked, sooner), false, 0},
+		{"shorten unlocked", enabled, retained(retentionUnlocked, later), retained(retentionUnlocked, sooner), false, 400},
+		{"shorten unlocked with override", enabled, retained(retentionUnlocked, later), retained(retentionUnlocked, sooner), true, 0},
+		{"remove unlocked", enabled, retained(retentionUnlocked, later), objectAttrs{}, false, 400},
+		{"remove unlocked with override", enabled, retained(retentionUnlocked, later), objectAttrs{}, true, 0},
+		{"shorten locked", enabled, retained(retentionLocked, later), retained(retentionLocked, sooner), false, 403},
+		{"shorten locked with override", enabled, retained(retentionLocked, later), retained(retentionLocked, sooner), true, 403},
+		{"unlock locked", enabled, retained(retentionLocked, later), retained(retentionUnlocked, later), true, 403},
+		{"remove locked", enabled, retained(retentionLocked, later), objectAttrs{}, true, 403},
+		{"remove expired locked", enabled, retained(retentionLocked, now.Add(-time.Hour)), objectAttrs{}, false, 0},
+	}
+
+	for _, tt := range tests {
+		err := checkObjectRetention(tt.parent, tt.previous, tt.attrs, tt.override)
+		switch {
+		case tt.status == 0 && err != nil:
+			t.Errorf("%s: checkObjectRetention() = %v, want success", tt.name, err)
+		case tt.status != 0 && (err == nil || err.(*apiError).Code != tt.status):
+			t.Errorf("%s: checkObjectRetention() = %v, want %d", tt.name, err, tt.status)
+		}
+	}
+}
@@ -0,0 +1,61 @@
+package storage
+
+import "net/http"
+
+// Routes GCS JSON API calls to native handlers, anything else falls through to the MinIO proxy
+func (s *StorageService) newRouter() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /storage/v1/b", s.authorizeProject("storage.buckets.list", s.handleListBuckets))
+	mux.HandleFunc("POST /storage/v1/b", s.authorizeProject("storage.buckets.create", s.handleInsertBucket))
+	mux.HandleFunc("GET /storage/v1/b/{bucket}", s.authorize("storage.buckets.get", s.handleGetBucket))
+	mux.HandleFunc("PATCH /storage/v1/b/{bucket}", s.authorize("storage.buckets.update", s.handlePatchBucket))
+	mux.HandleFunc("DELETE /storage/v1/b/{bucket}", s.authorize("storage.buckets.delete", s.handleDeleteBucket))
+	mux.HandleFunc("POST /storage/v1/b/{bucket}/lockRetentionPolicy", s.authorize("storage.buckets.update", s.handleLockRetentionPolicy))
+
+	mux.HandleFunc("GET /storage/v1/b/{bucket}/iam", s.authorize("storage.buckets.getIamPolicy", s.handleGetBucketIamPolicy))
+	mux.HandleFunc("PUT /storage/v1/b/{bucket}/iam", s.authorize("storage.buckets.setIamPolicy", s.handleSetBucketIamPolicy))
+	mux.HandleFunc("GET /storage/v1/b/{bucket}/iam/testPermissions", s.handleTestBucketIamPermissions)
+
+	mux.HandleFunc("GET /storage/v1/b/{bucket}/notificationConfigs", s.authorize("storage.buckets.get", s.handleListNotifications))
+	mux.HandleFunc("POST /storage/v1/b/{bucket}/notificationConfigs", s.authorize("storage.buckets.update", s.handleInsertNotification))
+	mux.HandleFunc("GET /storage/v1/b/{bucket}/notificationConfigs/{notification}", s.authorize("storage.buckets.get", s.handleGetNotification))
+	mux.HandleFunc("DELETE /storage/v1/b/{bucket}/notificationConfigs/{notification}", s.authorize("storage.buckets.update", s.handleDeleteNotification))
+
+	s.handleACLRoutes(mux, "/storage/v1/b/{bucket}/acl", bucketACL,
+		"storage.buckets.getIamPolicy", "storage.buckets.setIamPolicy")
+	s.handleACLRoutes(mux, "/storage/v1/b/{bucket}/defaultObjectAcl", defaultObjectACL,
+		"storage.buckets.getIamPolicy", "storage.buckets.setIamPolicy")
+	s.handleACLRoutes(mux, "/storage/v1/b/{bucket}/o/{object}/acl", objectACL,
+		"storage.objects.getIamPolicy", "storage.objects.setIamPolicy")
+
+	mux.HandleFunc("GET /storage/v1/b/{bucket}/o", s.authorize("storage.objects.list", s.handleListObjects))
+	mux.HandleFunc("GET /storage/v1/b/{bucket}/o/{object}", s.authorize("storage.objects.get", s.handleGetObject))
+	mux.HandleFunc("PATCH /storage/v1/b/{bucket}/o/{object}", s.authorize("storage.objects.update", s.handlePatchObject))
+	mux.HandleFunc("PUT /storage/v1/b/{bucket}/o/{object}", s.authorize("storage.objects.update", s.handleUpdateObject))
+	mux.HandleFunc("DELETE /storage/v1/b/{bucket}/o/{object}", s.authorize("storage.objects.delete", s.handleDeleteObject))
+	mux.HandleFunc("POST /storage/v1/b/{bucket}/o/{object}/compose",
+		s.authorize("storage.objects.get", s.authorize("storage.objects.create", s.handleComposeObject)))
+	mux.HandleFunc("POST /storage/v1/b/{bucket}/o/{object}/restore", s.authorize("storage.objects.create", s.handleRestoreObject))
+	mux.HandleFunc("POST /storage/v1/b/{bucket}/o/{object}/copyTo/b/{destinationBucket}/o/{destinationObject}",
+		s.authorize("storage.objects.get", s.authorizeOn("destinationBucket", "storage.objects.create", s.handleCopyObject)))
+	mux.HandleFunc("POST /storage/v1/b/{bucket}/o/{object}/rewriteTo/b/{destinationBucket}/o/{destinationObject}",
+		s.authorize("storage.objects.get", s.authorizeOn("destinationBucket", "storage.objects.create", s.handleRewriteObject)))
+
+	mux.HandleFunc("GET /download/storage/v1/b/{bucket}/o/{object}", s.authorize("storage.objects.get", s.handleDownloadObject))
+	mux.HandleFunc("POST /upload/storage/v1/b/{bucket}/o", s.authorize("storage.objects.create", s.handleUploadObject))
+	mux.HandleFunc("PUT /upload/storage/v1/b/{bucket}/o", s.authorize("storage.objects.create", s.handleResumableChunk))
+	mux.HandleFunc("DELETE /upload/storage/v1/b/{bucket}/o", s.authorize("storage.objects.create", s.handleCancelResumableUpload))
+
+	mux.HandleFunc("GET /storage/v1/projects/{project}/hmacKeys", s.authorizeProject("storage.hmacKeys.list", s.handleListHMACKeys))
+	mux.HandleFunc("POST /storage/v1/projects/{project}/hmacKeys", s.authorizeProject("storage.hmacKeys.create", s.handleCreateHMACKey))
+	mux.HandleFunc("GET /storage/v1/projects/{project}/hmacKeys/{accessId}", s.authorizeProject("storage.hmacKeys.get", s.handleGetHMACKey))
+	mux.HandleFunc("PUT /storage/v1/projects/{project}/hmacKeys/{accessId}", s.authorizeProject("storage.hmacKeys.update", s.handleUpdateHMACKey))
+	mux.HandleFunc("DELETE /storage/v1/projects/{project}/hmacKeys/{accessId}", s.authorizeProject("storage.hmacKeys.delete", s.handleDeleteHMACKey))
+
+	mux.HandleFunc("POST /batch/storage/v1", s.handleBatch)
+
+	mux.HandleFunc("/", s.proxyRequest)
+
+	return hideSoftDeleteBucket(jsonPathBuckets, mux)
+}
@@ -1,13 +1,22 @@
 package storage
 
 import (
+	"bytes"
 	"context"
+	"encoding/json"
+	"encoding/xml"
 	"fmt"
+	"io"
 	"net/http"
 	"net/http/httputil"
 	"net/url"
+	"strconv"
 	"strings"
+	"time"
 
+	"github.com/minio/madmin-go/v3"
+	"github.com/minio/minio-go/v7"
+	"github.com/minio/minio-go/v7/pkg/credentials"
 	"github.com/thegenem0/glocal/pkg/config"
 	"github.com/thegenem0/glocal/pkg/containers"
 	"github.com/thegenem0/glocal/pkg/services/base"
@@ -16,13 +25,32 @@ import (
 
 type StorageService struct {
 	*base.ContainerService
+	config     Config
+	logger     *zap.Logger
+	client     *minio.Client
+	admin      *madmin.AdminClient
+	core       *minio.Core
 	proxy      *httputil.ReverseProxy
+	xmlProxy   *httputil.ReverseProxy
 	translator *APITranslator
+	router     http.Handler
+	xmlRouter  http.Handler
+	buckets    *bucketStore
+	uploads    *uploadSessionManager
+	locks      *objectLocks
+	acls       *aclStore
+
+	notifications *notificationStore
+	publisher     *pubsubPublisher
+
+	signingKeys *signingKeys
+	hmacKeys    *hmacKeyStore
 }
 
 func NewStorageService(
 	containerMgr *containers.ContainerManager,
 	containerConfig config.ContainerConfig,
+	storageConfig Config,
 	logger *zap.Logger,
 ) *StorageService {
 	contaierService := base.NewContainerServcie(
@@ -35,12 +63,25 @@ func NewStorageService(
 
 	service := &StorageService{
 		ContainerService: contaierService,
+		config:           storageConfig,
+		logger:           logger,
 		translator:       NewAPITranslator(logger),
+		buckets:          newBucketStore(),
+		uploads:          newUploadSessionManager(storageConfig.ResumableSessionTTL),
+		locks:            newObjectLocks(),
+		acls:             newACLStore(),
+		notifications:    newNotificationStore(),
+		publisher:        newPubSubPublisher(storageConfig.PubSubEndpoint, logger),
+		hmacKeys:         newHMACKeyStore(),
 	}
 
+	service.router = service.newRouter()
+	service.xmlRouter = service.newXMLRouter()
+
 	service.SetRoutes([]string{
 		"/storage/*path",
 		"/upload/storage/*path",
+		"/download/storage/*path",
 		"/batch/storage/*path",
 	})
 
@@ -68,6 +109,57 @@ func (s *StorageService) Initialize(ctx context.Context) error {
 	s.proxy = httputil.NewSingleHostReverseProxy(target)
 	s.proxy.Director = s.createProxyDirector(target)
 	s.proxy.ErrorHandler = s.proxyErrorHandler
+	s.proxy.ModifyResponse = s.rewriteProxyError
+
+	s.xmlProxy = &httputil.ReverseProxy{
+		Rewrite:        s.createXMLProxyRewrite(target),
+		ModifyResponse: s.rewriteXMLProxyResponse,
+		ErrorHandler:   s.proxyErrorHandler,
+	}
+
+	client, err := minio.New(target.Host, &minio.Options{
+		Creds:  credentials.NewStaticV4(s.config.AccessKey, s.config.SecretKey, ""),
+		Secure: target.Scheme == "https",
+	})
+	if err != nil {
+		return fmt.Errorf("failed to create MinIO client: %w", err)
+	}
+
+	s.client = client
+	s.core = &minio.Core{Client: client}
+
+	// HMAC keys are provisioned as MinIO users through the admin API
+	s.admin, err = madmin.New(target.Host, s.config.AccessKey, s.config.SecretKey, target.Scheme == "https")
+	if err != nil {
+		return fmt.Errorf("failed to create MinIO admin client: %w", err)
+	}
+
+	s.signingKeys, err = loadSigningKeys(s.config, s.hmacKeys)
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+func (s *StorageService) Start(ctx context.Context) error {
+	if err := s.ContainerService.Start(ctx); err != nil {
+		return err
+	}
+
+	err := s.client.MakeBucket(ctx, softDeleteBucket, minio.MakeBucketOptions{})
+	if err != nil && minio.ToErrorResponse(err).Code != "BucketAlreadyOwnedByYou" {
+		return fmt.Errorf("failed to create soft delete bucket: %w", err)
+	}
+
+	go s.expireUploadSessions(ctx, time.Minute)
+	go s.runLifecycle(ctx, s.config.LifecycleInterval)
+	go s.expireSoftDeleted(ctx, s.config.LifecycleInterval)
+	go s.publisher.Run(ctx)
+
+	if s.config.PubSubEndpoint == "" {
+		s.logger.Warn("No pubsub_endpoint configured, object change notifications won't be published")
+	}
 
 	return nil
 }
@@ -76,7 +168,49 @@ func (s *StorageService) Handler() http.Handler {
 	return http.HandlerFunc(s.handleRequest)
 }
 
+// Serves the XML API, which lives at the root of the host, for requests no other service route matched
+func (s *StorageService) FallbackHandler() http.Handler {
+	return http.HandlerFunc(s.handleRequest)
+}
+
 func (s *StorageService) handleRequest(w http.ResponseWriter, r *http.Request) {
+	virtualBucket := virtualHostBucket(r.Host)
+
+	if r.Header.Get("Origin") != "" && s.handleCORS(w, r, corsBucket(r, virtualBucket)) {
+		return
+	}
+
+	if virtualBucket == "" && isJSONAPIPath(r.URL.Path) {
+		s.router.ServeHTTP(w, r)
+		return
+	}
+
+	xw := &xmlResponseWriter{ResponseWriter: w}
+
+	// Signatures cover the path as the client sent it, so check them
+	// before a virtual-hosted request is rewritten to path style
+	if isSignedURL(r) {
+		if err := s.verifySignedURL(r); err != nil {
+			writeError(xw, err)
+			return
+		}
+	}
+
+	if err := s.verifyHMACAuthorization(r, virtualBucket); err != nil {
+		writeError(xw, err)
+		return
+	}
+
+	if virtualBucket != "" {
+		toPathStyle(r, virtualBucket)
+	}
+
+	decodeAWSChunked(r)
+
+	s.xmlRouter.ServeHTTP(xw, r)
+}
+
+func (s *StorageService) proxyRequest(w http.ResponseWriter, r *http.Request) {
 	// s.logger.Debug("Handling storage request",
 	// 	zap.String("method", r.Method),
 	// 	zap.String("path", r.URL.Path),
@@ -84,7 +218,7 @@ func (s *StorageService) handleRequest(w http.ResponseWriter, r *http.Request) {
 
 	if err := s.translator.TranslateRequest(r); err != nil {
 		//s.logger.Error("Failed to translate request", zap.Error(err))
-		http.Error(w, "Failed to translate request", http.StatusInternalServerError)
+		writeError(w, newAPIError(http.StatusInternalServerError, "backendError", "Failed to translate request: %v", err))
 		return
 	}
 
@@ -100,10 +234,62 @@ func (s *StorageService) createProxyDirector(target *url.URL) func(*http.Request
 		if r.URL.Path == "" {
 			r.URL.Path = "/"
 		}
+
+		// MinIO answers any origin with CORS headers of its own, while bucket CORS rules are applied by glocal
+		r.Header.Del("Origin")
 	}
 }
 
 func (s *StorageService) proxyErrorHandler(w http.ResponseWriter, r *http.Request, err error) {
-	// s.logger.Error("Proxy error", zap.Error(err))
-	http.Error(w, "Service temporarily unavailable", http.StatusServiceUnavailable)
+	s.logger.Error("Proxy error", zap.Error(err))
+	writeError(w, newAPIError(http.StatusServiceUnavailable, "backendError", "Service temporarily unavailable"))
+}
+
+// Replaces S3 XML error bodies coming back from MinIO with GCS JSON errors,
+// so that client libraries can recognise them
+func (s *StorageService) rewriteProxyError(resp *http.Response) error {
+	if resp.StatusCode < http.StatusBadRequest {
+		return nil
+	}
+
+	apiErr, err := readProxyError(resp)
+	if err != nil {
+		return err
+	}
+
+	payload, err := json.Marshal(newErrorBody(apiErr))
+	if err != nil {
+		return fmt.Errorf("failed to encode error response: %w", err)
+	}
+
+	replaceErrorBody(resp, apiErr.Code, "application/json; charset=UTF-8", payload)
+
+	return nil
+}
+
+// Parses the S3 error MinIO answered a proxied request with
+func readProxyError(resp *http.Response) (*apiError, error) {
+	body, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	resp.Body.Close()
+	if err != nil {
+		return nil, fmt.Errorf("failed to read MinIO error response: %w", err)
+	}
+
+	s3Err := minio.ErrorResponse{StatusCode: resp.StatusCode}
+	if err := xml.Unmarshal(body, &s3Err); err != nil {
+		// HEAD responses and some gateway errors carry no XML body
+		s3Err.Code = resp.Header.Get("X-Minio-Error-Code")
+		s3Err.Message = resp.Header.Get("X-Minio-Error-Desc")
+	}
+
+	return fromS3Error(s3Err), nil
+}
+
+func replaceErrorBody(resp *http.Response, code int, contentType string, payload []byte) {
+	resp.StatusCode = code
+	resp.Status = fmt.Sprintf("%d %s", code, http.StatusText(code))
+	resp.Body = io.NopCloser(bytes.NewReader(payload))
+	resp.ContentLength = int64(len(payload))
+	resp.Header.Set("Content-Type", contentType)
+	resp.Header.Set("Content-Length", strconv.Itoa(len(payload)))
 }
@@ -0,0 +1,341 @@
+package storage
+
+import (
+	"cmp"
+	"context"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/minio/minio-go/v7"
+	"go.uber.org/zap"
+)
+
+// MinIO bucket holding soft-deleted generations until their hard delete time, since MinIO
+// has no way of keeping a removed version out of listings. Each generation is kept under
+// `<bucket>/<name>/<generation>` with the metadata it had when it was deleted. It is
+// created on start and its name is reserved.
+const softDeleteBucket = "glocal-soft-deleted"
+
+// Bounds GCS puts on a soft delete policy's retention duration, besides zero which turns soft delete off
+const (
+	minSoftDeleteRetention = 7 * 24 * 60 * 60
+	maxSoftDeleteRetention = 90 * 24 * 60 * 60
+)
+
+func softDeleteKey(bucket, name string, generation int64) string {
+	return bucket + "/" + name + "/" + strconv.FormatInt(generation, 10)
+}
+
+// Answers requests naming the reserved soft delete bucket as if it didn't exist,
+// so that its copies can only be reached through soft delete handling
+func hideSoftDeleteBucket(buckets func(*http.Request) []string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if slices.Contains(buckets(r), softDeleteBucket) {
+			err := errNotFound("The specified bucket does not exist.")
+			err.XMLCode = "NoSuchBucket"
+			writeError(w, err)
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
+
+// Returns the buckets a JSON API path names, which follow a `b` segment.
+// Object names are escaped into a single segment, so they can't pass for one.
+func jsonPathBuckets(r *http.Request) []string {
+	var buckets []string
+
+	segments := strings.Split(r.URL.EscapedPath(), "/")
+	for i := 1; i < len(segments); i++ {
+		if segments[i-1] == "b" {
+			buckets = append(buckets, segments[i])
+		}
+	}
+
+	return buckets
+}
+
+// Returns the bucket of a path-style XML API request, along with the one it copies from
+func xmlPathBuckets(r *http.Request) []string {
+	bucket, _, _ := strings.Cut(strings.TrimPrefix(r.URL.Path, "/"), "/")
+	buckets := []string{bucket}
+
+	if source := xmlCopySource(r.Header); source != "" {
+		buckets = append(buckets, xmlCopySourceBucket(source))
+	}
+
+	return buckets
+}
+
+// Recovers the object name from a key in the soft delete bucket
+func softDeletedName(bucket, key string) string {
+	name := strings.TrimPrefix(key, bucket+"/")
+	if idx := strings.LastIndex(name, "/"); idx >= 0 {
+		name = name[:idx]
+	}
+
+	return name
+}
+
+// Sets the soft delete retention, restarting its effective time whenever the duration changes
+func (attrs *bucketAttrs) setSoftDeletePolicy(policy *BucketSoftDeletePolicy) error {
+	retention := int64(0)
+	if policy != nil {
+		retention = policy.RetentionDurationSeconds
+	}
+
+	if retention != 0 && (retention < minSoftDeleteRetention || retention > maxSoftDeleteRetention) {
+		return errBadRequest("Invalid value for softDeletePolicy.retentionDurationSeconds: %d. It must be 0 or between %d and %d seconds.",
+			retention, minSoftDeleteRetention, maxSoftDeleteRetention)
+	}
+
+	if retention != attrs.SoftDeleteRetention {
+		attrs.SoftDeleteEffectiveTime = time.Now()
+	}
+	attrs.SoftDeleteRetention = retention
+
+	return nil
+}
+
+// Keeps a copy of a version that is about to be removed for good, when the bucket has a soft delete policy
+func (s *StorageService) softDelete(ctx context.Context, bucket string, info minio.ObjectInfo) error {
+	retention := s.buckets.Get(bucket, s.defaultBucketAttrs(time.Time{})).SoftDeleteRetention
+	if retention == 0 {
+		return nil
+	}
+
+	attrs := parseObjectAttrs(info)
+	attrs.SoftDeleted = time.Now()
+	attrs.HardDeleted = attrs.SoftDeleted.Add(time.Duration(retention) * time.Second)
+
+	// Composing copies sources over 5 GiB part by part, where a plain copy would fail
+	_, err := s.client.ComposeObject(ctx, attrs.copyDestOptions(softDeleteBucket, softDeleteKey(bucket, info.Key, attrs.Generation)), minio.CopySrcOptions{
+		Bucket:    bucket,
+		Object:    info.Key,
+		VersionID: info.VersionID,
+	})
+	return err
+}
+
+// Drops the soft-deleted copy of a version, for writes that kept it but then failed
+func (s *StorageService) removeSoftDeleted(ctx context.Context, bucket string, info minio.ObjectInfo) {
+	key := softDeleteKey(bucket, info.Key, parseObjectAttrs(info).Generation)
+
+	if err := s.client.RemoveObject(ctx, softDeleteBucket, key, minio.RemoveObjectOptions{}); err != nil {
+		s.logger.Warn("Failed to remove soft-deleted copy", zap.String("bucket", bucket), zap.String("object", info.Key), zap.Error(err))
+	}
+}
+
+// Stats a soft-deleted generation, given as the `generation` query parameter
+func (s *StorageService) statSoftDeleted(ctx context.Context, bucket, name, generation string) (minio.ObjectInfo, error) {
+	if generation == "" {
+		return minio.ObjectInfo{}, errBadRequest("Required parameter: generation")
+	}
+
+	gen, err := strconv.ParseInt(generation, 10, 64)
+	if err != nil {
+		return minio.ObjectInfo{}, errBadRequest("Invalid value for generation: %s", generation)
+	}
+
+	info, err := s.client.StatObject(ctx, softDeleteBucket, softDeleteKey(bucket, name, gen), minio.StatObjectOptions{Checksum: true})
+	if code := minio.ToErrorResponse(err).Code; code == "NoSuchKey" || code == "NoSuchBucket" {
+		return minio.ObjectInfo{}, errNotFound("No such object: %s/%s#%d", bucket, name, gen)
+	}
+
+	return info, err
+}
+
+// Renders a soft-deleted generation under the name it had in its bucket
+func (s *StorageService) renderSoftDeleted(r *http.Request, bucket string, info minio.ObjectInfo) *Object {
+	info.Key = softDeletedName(bucket, info.Key)
+	return s.renderObject(r, bucket, info)
+}
+
+// Lists the soft-deleted generations of a bucket. The soft delete bucket orders keys by
+// `<name>/` rather than by name, so entries are sorted here before they are paged.
+func (s *StorageService) listSoftDeleted(ctx context.Context, r *http.Request, bucket string, lq listQuery) (*Objects, error) {
+	result := &Objects{
+		Kind:  "storage#objects",
+		Items: []*Object{},
+	}
+
+	type entry struct {
+		name       string
+		generation int64
+		info       minio.ObjectInfo
+	}
+
+	var entries []entry
+	err := s.walkObjects(ctx, softDeleteBucket, minio.ListObjectsOptions{
+		Prefix:       bucket + "/" + lq.Prefix,
+		Recursive:    true,
+		WithMetadata: true,
+	}, func(info minio.ObjectInfo) bool {
+		name := softDeletedName(bucket, info.Key)
+		if strings.HasPrefix(name, lq.Prefix) {
+			entries = append(entries, entry{name, parseObjectAttrs(info).Generation, info})
+		}
+		return true
+	})
+	if minio.ToErrorResponse(err).Code == "NoSuchBucket" {
+		return result, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	slices.SortFunc(entries, func(a, b entry) int {
+		if a.name != b.name {
+			return strings.Compare(a.name, b.name)
+		}
+		return cmp.Compare(a.generation, b.generation)
+	})
+
+	count := 0
+	lastToken := ""
+
+	for _, e := range entries {
+		if lq.seen(e.name, e.generation) || e.name < lq.StartOffset {
+			continue
+		}
+
+		if lq.EndOffset != "" && e.name >= lq.EndOffset {
+			break
+		}
+
+		commonPrefix := ""
+		if lq.Delimiter != "" {
+			rest := strings.TrimPrefix(e.name, lq.Prefix)
+			if idx := strings.Index(rest, lq.Delimiter); idx >= 0 {
+				commonPrefix = lq.Prefix + rest[:idx+len(lq.Delimiter)]
+			}
+		}
+
+		if commonPrefix != "" {
+			if commonPrefix <= lq.StartAfter || commonPrefix == lastToken {
+				continue
+			}
+
+			if count == lq.MaxResults {
+				result.NextPageToken = encodePageToken(lastToken)
+				break
+			}
+
+			result.Prefixes = append(result.Prefixes, commonPrefix)
+			count++
+			lastToken = commonPrefix
+			continue
+		}
+
+		if !s.matchesGlob(lq, e.name) {
+			continue
+		}
+
+		if count == lq.MaxResults {
+			result.NextPageToken = encodePageToken(lastToken)
+			break
+		}
+
+		result.Items = append(result.Items, s.renderSoftDeleted(r, bucket, e.info))
+		count++
+		lastToken = e.name + "\n" + strconv.FormatInt(e.generation, 10)
+	}
+
+	return result, nil
+}
+
+// Restores a soft-deleted generation as a new live generation of the object
+func (s *StorageService) handleRestoreObject(w http.ResponseWriter, r *http.Request) {
+	bucket := r.PathValue("bucket")
+	name := r.PathValue("object")
+
+	conds, err := parsePreconditions(r.URL.Query())
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	// Restored data stays encrypted with the key it was written with
+	key, err := parseCustomerKey(r.Header, encryptionHeaderPrefix)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	src, err := s.statSoftDeleted(r.Context(), bucket, name, r.URL.Query().Get("generation"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	info, err := s.copyObject(r.Context(), softDeleteBucket, src, bucket, name, &Object{}, conds, key, key)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	s.initObjectACL(bucket, info, nil)
+
+	if err := s.client.RemoveObject(r.Context(), softDeleteBucket, src.Key, minio.RemoveObjectOptions{}); err != nil {
+		writeError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, s.renderObject(r, bucket, info))
+}
+
+// Removes the soft-deleted generations of a bucket, or of all buckets when it is empty.
+// Only generations past their hard delete time are removed when before is set.
+func (s *StorageService) purgeSoftDeleted(ctx context.Context, bucket string, before time.Time) error {
+	prefix := ""
+	if bucket != "" {
+		prefix = bucket + "/"
+	}
+
+	var expired []string
+	err := s.walkObjects(ctx, softDeleteBucket, minio.ListObjectsOptions{
+		Prefix:       prefix,
+		Recursive:    true,
+		WithMetadata: true,
+	}, func(info minio.ObjectInfo) bool {
+		if hardDeleted := parseObjectAttrs(info).HardDeleted; before.IsZero() || hardDeleted.Before(before) {
+			expired = append(expired, info.Key)
+		}
+		return true
+	})
+	if minio.ToErrorResponse(err).Code == "NoSuchBucket" {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	for _, key := range expired {
+		if err := s.client.RemoveObject(ctx, softDeleteBucket, key, minio.RemoveObjectOptions{}); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Periodically removes soft-deleted generations whose retention has run out
+func (s *StorageService) expireSoftDeleted(ctx context.Context, interval time.Duration) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case now := <-ticker.C:
+			if err := s.purgeSoftDeleted(ctx, "", now); err != nil {
+				s.logger.Warn("Failed to remove expired soft-deleted objects", zap.Error(err))
+			}
+		}
+	}
+}
@@ -0,0 +1,55 @@
+package storage
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestHideSoftDeleteBucket(t *testing.T) {
+	tests := []struct {
+		name       string
+		buckets    func(*http.Request) []string
+		method     string
+		target     string
+		copySource string
+		hidden     bool
+	}{
+		{"JSON bucket", jsonPathBuckets, "GET", "/storage/v1/b/glocal-soft-deleted", "", true},
+		{"JSON object", jsonPathBuckets, "GET", "/storage/v1/b/glocal-soft-deleted/o/object", "", true},
+		{"JSON upload", jsonPathBuckets, "POST", "/upload/storage/v1/b/glocal-soft-deleted/o", "", true},
+		{"JSON copy destination", jsonPathBuckets, "POST", "/storage/v1/b/bucket/o/object/copyTo/b/glocal-soft-deleted/o/object", "", true},
+		{"JSON other bucket", jsonPathBuckets, "GET", "/storage/v1/b/bucket/o/object", "", false},
+		{"JSON escaped object name", jsonPathBuckets, "GET", "/storage/v1/b/bucket/o/b%2Fglocal-soft-deleted", "", false},
+		{"JSON bucket list", jsonPathBuckets, "GET", "/storage/v1/b?project=p", "", false},
+		{"XML bucket", xmlPathBuckets, "DELETE", "/glocal-soft-deleted", "", true},
+		{"XML object", xmlPathBuckets, "GET", "/glocal-soft-deleted/bucket/object/1", "", true},
+		{"XML copy source", xmlPathBuckets, "PUT", "/bucket/object", "/glocal-soft-deleted/bucket/object/1", true},
+		{"XML other bucket", xmlPathBuckets, "GET", "/bucket/glocal-soft-deleted", "", false},
+		{"XML service", xmlPathBuckets, "GET", "/", "", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			served := false
+			handler := hideSoftDeleteBucket(tt.buckets, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				served = true
+			}))
+
+			r := httptest.NewRequest(tt.method, tt.target, nil)
+			if tt.copySource != "" {
+				r.Header.Set("X-Goog-Copy-Source", tt.copySource)
+			}
+
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			if served == tt.hidden {
+				t.Errorf("served = %v, want %v", served, !tt.hidden)
+			}
+			if tt.hidden && w.Code != http.StatusNotFound {
+				t.Errorf("status = %d, want %d", w.Code, http.StatusNotFound)
+			}
+		})
+	}
+}
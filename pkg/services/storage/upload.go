@@ -0,0 +1,346 @@
+package storage
+
+import (
+	"context"
+	"crypto/cipher"
+	"crypto/md5"
+	"encoding/base64"
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"hash/crc32"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"os"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+)
+
+var crc32cTable = crc32.MakeTable(crc32.Castagnoli)
+
+// Object data spooled to disk, so that its hashes are known before it is written to MinIO
+type spooledUpload struct {
+	file   *os.File
+	size   int64
+	md5    string
+	crc32c string
+}
+
+func spoolUpload(r io.Reader) (*spooledUpload, error) {
+	file, err := os.CreateTemp("", "glocal-upload-*")
+	if err != nil {
+		return nil, fmt.Errorf("failed to create upload spool file: %w", err)
+	}
+
+	md5Hash := md5.New()
+	crcHash := crc32.New(crc32cTable)
+
+	size, err := io.Copy(io.MultiWriter(file, md5Hash, crcHash), r)
+	if err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return nil, errBadRequest("Failed to read upload data: %v", err)
+	}
+
+	if _, err := file.Seek(0, io.SeekStart); err != nil {
+		file.Close()
+		os.Remove(file.Name())
+		return nil, fmt.Errorf("failed to rewind upload spool file: %w", err)
+	}
+
+	return &spooledUpload{
+		file:   file,
+		size:   size,
+		md5:    base64.StdEncoding.EncodeToString(md5Hash.Sum(nil)),
+		crc32c: encodeCRC32C(crcHash.Sum32()),
+	}, nil
+}
+
+func (u *spooledUpload) Close() error {
+	u.file.Close()
+	return os.Remove(u.file.Name())
+}
+
+// Checks the hashes a client sent along with the object metadata
+func (u *spooledUpload) verify(md5Hash, crc32c string) error {
+	if md5Hash != "" && md5Hash != u.md5 {
+		return errBadRequest("Provided MD5 hash \"%s\" doesn't match calculated MD5 hash \"%s\".", md5Hash, u.md5)
+	}
+
+	if crc32c != "" && crc32c != u.crc32c {
+		return errBadRequest("Provided CRC32C \"%s\" doesn't match calculated CRC32C \"%s\".", crc32c, u.crc32c)
+	}
+
+	return nil
+}
+
+func encodeCRC32C(sum uint32) string {
+	var buf [4]byte
+	binary.BigEndian.PutUint32(buf[:], sum)
+
+	return base64.StdEncoding.EncodeToString(buf[:])
+}
+
+// Builds the attributes of a new object from the metadata a client sent with it
+func attrsFromResource(obj *Object) (objectAttrs, error) {
+	attrs := objectAttrs{
+		ContentType:        obj.ContentType,
+		ContentEncoding:    obj.ContentEncoding,
+		ContentDisposition: obj.ContentDisposition,
+		ContentLanguage:    obj.ContentLanguage,
+		CacheControl:       obj.CacheControl,
+		StorageClass:       obj.StorageClass,
+		Metadata:           obj.Metadata,
+		KMSKeyName:         obj.KmsKeyName,
+		TemporaryHold:      obj.TemporaryHold != nil && *obj.TemporaryHold,
+		EventBasedHold:     obj.EventBasedHold != nil && *obj.EventBasedHold,
+	}
+
+	if err := attrs.setCustomTime(obj.CustomTime); err != nil {
+		return objectAttrs{}, err
+	}
+
+	if err := attrs.setRetention(obj.Retention); err != nil {
+		return objectAttrs{}, err
+	}
+
+	return attrs, nil
+}
+
+// Writes spooled data as a new generation of the object and returns its stored state.
+// The data is encrypted at rest when a customer-supplied key is given.
+func (s *StorageService) writeObject(ctx context.Context, bucket, name string, data *spooledUpload, attrs objectAttrs, key *customerKey) (minio.ObjectInfo, error) {
+	attrs.startGeneration()
+	attrs.CRC32C = data.crc32c
+	if attrs.ComponentCount == 0 {
+		attrs.MD5 = data.md5
+	}
+
+	if attrs.ContentType == "" {
+		attrs.ContentType = "application/octet-stream"
+	}
+
+	if err := s.applyBucketRetention(bucket, &attrs); err != nil {
+		return minio.ObjectInfo{}, err
+	}
+
+	stream, err := s.setupEncryption(bucket, &attrs, key)
+	if err != nil {
+		return minio.ObjectInfo{}, err
+	}
+
+	var body io.Reader = data.file
+	if stream != nil {
+		body = cipher.StreamReader{S: stream, R: data.file}
+	}
+
+	replaced, undo, err := s.replacedVersion(ctx, bucket, name)
+	if err != nil {
+		return minio.ObjectInfo{}, err
+	}
+
+	if _, err := s.client.PutObject(ctx, bucket, name, body, data.size, attrs.putOptions()); err != nil {
+		undo()
+		return minio.ObjectInfo{}, err
+	}
+
+	info, err := s.client.StatObject(ctx, bucket, name, minio.StatObjectOptions{})
+	if err != nil {
+		return minio.ObjectInfo{}, err
+	}
+
+	s.notifyFinalize(bucket, replaced, info)
+
+	return info, nil
+}
+
+// Rewrites an object's metadata in place, which MinIO does without copying the data for objects up to 5 GiB
+func (s *StorageService) replaceObjectAttrs(ctx context.Context, bucket, name string, attrs objectAttrs) (minio.ObjectInfo, error) {
+	current, err := s.client.StatObject(ctx, bucket, name, minio.StatObjectOptions{})
+	if err != nil {
+		return minio.ObjectInfo{}, err
+	}
+
+	src := minio.CopySrcOptions{
+		Bucket:    bucket,
+		Object:    name,
+		VersionID: current.VersionID,
+	}
+
+	// Composing copies objects over 5 GiB part by part, where a plain copy would fail
+	uploaded, err := s.client.ComposeObject(ctx, attrs.copyDestOptions(bucket, name), src)
+	if err != nil {
+		return minio.ObjectInfo{}, err
+	}
+
+	// In a versioned bucket the copy becomes a new version. Drop the one it
+	// replaced, since GCS keeps a single version per generation.
+	if current.VersionID != "" && current.VersionID != uploaded.VersionID {
+		if err := s.client.RemoveObject(ctx, bucket, name, minio.RemoveObjectOptions{VersionID: current.VersionID}); err != nil {
+			return minio.ObjectInfo{}, err
+		}
+	}
+
+	return s.client.StatObject(ctx, bucket, name, minio.StatObjectOptions{VersionID: uploaded.VersionID})
+}
+
+func (s *StorageService) handleUploadObject(w http.ResponseWriter, r *http.Request) {
+	// Some clients send resumable chunks as POSTs to the session URI
+	if r.URL.Query().Has("upload_id") {
+		s.handleResumableChunk(w, r)
+		return
+	}
+
+	uploadType := r.URL.Query().Get("uploadType")
+	if uploadType == "" {
+		uploadType = "media"
+		if strings.HasPrefix(r.Header.Get("Content-Type"), "multipart/") {
+			uploadType = "multipart"
+		}
+	}
+
+	acl, err := s.parsePredefinedObjectACL(r.PathValue("bucket"), "predefinedAcl", r.URL.Query().Get("predefinedAcl"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	var info minio.ObjectInfo
+
+	switch uploadType {
+	case "resumable":
+		s.startResumableUpload(w, r)
+		return
+	case "media":
+		info, err = s.mediaUpload(r)
+	case "multipart":
+		info, err = s.multipartUpload(r)
+	default:
+		err = errBadRequest("Invalid upload type: %s", uploadType)
+	}
+
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	s.initObjectACL(r.PathValue("bucket"), info, acl)
+
+	writeJSON(w, http.StatusOK, s.renderObject(r, r.PathValue("bucket"), info))
+}
+
+// Handles `uploadType=media`, where the body is the object data and the name is a query parameter
+func (s *StorageService) mediaUpload(r *http.Request) (minio.ObjectInfo, error) {
+	name := r.URL.Query().Get("name")
+	if name == "" {
+		return minio.ObjectInfo{}, errBadRequest("Required parameter: name")
+	}
+
+	conds, err := parsePreconditions(r.URL.Query())
+	if err != nil {
+		return minio.ObjectInfo{}, err
+	}
+
+	key, err := parseCustomerKey(r.Header, encryptionHeaderPrefix)
+	if err != nil {
+		return minio.ObjectInfo{}, err
+	}
+
+	data, err := spoolUpload(r.Body)
+	if err != nil {
+		return minio.ObjectInfo{}, err
+	}
+	defer data.Close()
+
+	bucket := r.PathValue("bucket")
+
+	unlock, err := s.guardOverwrite(r.Context(), bucket, name, conds)
+	if err != nil {
+		return minio.ObjectInfo{}, err
+	}
+	defer unlock()
+
+	return s.writeObject(r.Context(), bucket, name, data, objectAttrs{
+		ContentType:     r.Header.Get("Content-Type"),
+		ContentEncoding: r.URL.Query().Get("contentEncoding"),
+		KMSKeyName:      r.URL.Query().Get("kmsKeyName"),
+	}, key)
+}
+
+// Handles `uploadType=multipart`, a multipart/related body holding the
+// object resource as JSON followed by the object data
+func (s *StorageService) multipartUpload(r *http.Request) (minio.ObjectInfo, error) {
+	conds, err := parsePreconditions(r.URL.Query())
+	if err != nil {
+		return minio.ObjectInfo{}, err
+	}
+
+	key, err := parseCustomerKey(r.Header, encryptionHeaderPrefix)
+	if err != nil {
+		return minio.ObjectInfo{}, err
+	}
+
+	mediaType, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+	if err != nil || !strings.HasPrefix(mediaType, "multipart/") || params["boundary"] == "" {
+		return minio.ObjectInfo{}, errBadRequest("Multipart upload requires a multipart/related body")
+	}
+
+	reader := multipart.NewReader(r.Body, params["boundary"])
+
+	metadataPart, err := reader.NextPart()
+	if err != nil {
+		return minio.ObjectInfo{}, errBadRequest("Missing metadata part in multipart upload: %v", err)
+	}
+
+	var resource Object
+	if err := json.NewDecoder(metadataPart).Decode(&resource); err != nil && !errors.Is(err, io.EOF) {
+		return minio.ObjectInfo{}, errBadRequest("Failed to parse object metadata: %v", err)
+	}
+
+	mediaPart, err := reader.NextPart()
+	if err != nil {
+		return minio.ObjectInfo{}, errBadRequest("Missing media part in multipart upload: %v", err)
+	}
+
+	if resource.Name == "" {
+		resource.Name = r.URL.Query().Get("name")
+	}
+	if resource.Name == "" {
+		return minio.ObjectInfo{}, errBadRequest("Required parameter: name")
+	}
+	if resource.KmsKeyName == "" {
+		resource.KmsKeyName = r.URL.Query().Get("kmsKeyName")
+	}
+
+	attrs, err := attrsFromResource(&resource)
+	if err != nil {
+		return minio.ObjectInfo{}, err
+	}
+	if attrs.ContentType == "" {
+		attrs.ContentType = mediaPart.Header.Get("Content-Type")
+	}
+
+	data, err := spoolUpload(mediaPart)
+	if err != nil {
+		return minio.ObjectInfo{}, err
+	}
+	defer data.Close()
+
+	if err := data.verify(resource.Md5Hash, resource.Crc32c); err != nil {
+		return minio.ObjectInfo{}, err
+	}
+
+	bucket := r.PathValue("bucket")
+
+	unlock, err := s.guardOverwrite(r.Context(), bucket, resource.Name, conds)
+	if err != nil {
+		return minio.ObjectInfo{}, err
+	}
+	defer unlock()
+
+	return s.writeObject(r.Context(), bucket, resource.Name, data, attrs, key)
+}
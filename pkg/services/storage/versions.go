@@ -0,0 +1,62 @@
+package storage
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// Finds the MinIO version holding a generation of an object. Every version
+// carries the generation it was written with, so the mapping survives restarts.
+func (s *StorageService) findGeneration(ctx context.Context, bucket, name string, generation int64) (minio.ObjectInfo, error) {
+	var found *minio.ObjectInfo
+
+	err := s.walkObjects(ctx, bucket, minio.ListObjectsOptions{
+		Prefix:       name,
+		Recursive:    true,
+		WithVersions: true,
+		WithMetadata: true,
+	}, func(info minio.ObjectInfo) bool {
+		if info.Key != name || info.IsDeleteMarker {
+			return true
+		}
+
+		if parseObjectAttrs(info).Generation == generation {
+			found = &info
+			return false
+		}
+
+		return true
+	})
+	if err != nil {
+		return minio.ObjectInfo{}, err
+	}
+
+	if found == nil {
+		return minio.ObjectInfo{}, errNotFound("No such object: %s/%s#%d", bucket, name, generation)
+	}
+
+	return *found, nil
+}
+
+// Stats the live version of an object, or the version holding the
+// generation given as the `generation` query parameter
+func (s *StorageService) statGeneration(ctx context.Context, bucket, name, generation string) (minio.ObjectInfo, error) {
+	opts := minio.StatObjectOptions{Checksum: true}
+
+	if generation != "" {
+		gen, err := strconv.ParseInt(generation, 10, 64)
+		if err != nil {
+			return minio.ObjectInfo{}, errBadRequest("Invalid value for generation: %s", generation)
+		}
+
+		version, err := s.findGeneration(ctx, bucket, name, gen)
+		if err != nil {
+			return minio.ObjectInfo{}, err
+		}
+		opts.VersionID = version.VersionID
+	}
+
+	return s.client.StatObject(ctx, bucket, name, opts)
+}
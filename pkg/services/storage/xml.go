@@ -0,0 +1,452 @@
+package storage
+
+import (
+	"context"
+	"encoding/xml"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/minio/minio-go/v7"
+)
+
+// Marks responses of the XML API, so that errors are rendered as XML
+type xmlResponseWriter struct {
+	http.ResponseWriter
+}
+
+// Path prefixes of the JSON API. Everything else on the host is the XML API.
+var jsonAPIPrefixes = []string{
+	"/storage/",
+	"/upload/storage/",
+	"/download/storage/",
+	"/batch/storage/",
+}
+
+func isJSONAPIPath(path string) bool {
+	for _, prefix := range jsonAPIPrefixes {
+		if strings.HasPrefix(path, prefix) {
+			return true
+		}
+	}
+
+	return false
+}
+
+// Routes path-style XML API requests. Object reads and writes, and the bucket requests
+// that change what glocal keeps track of, are served natively to keep GCS metadata.
+// Everything else is forwarded to MinIO.
+func (s *StorageService) newXMLRouter() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /{bucket}/{object...}", s.authorizeXML(s.xmlObjectRoute(s.handleDownloadObject)))
+	mux.HandleFunc("PUT /{bucket}/{object...}", s.authorizeXML(s.xmlObjectRoute(s.handleXMLPutObject)))
+	mux.HandleFunc("DELETE /{bucket}/{object...}", s.authorizeXML(s.xmlObjectRoute(s.handleDeleteObject)))
+
+	// Without it, requests for `/bucket` would be redirected to `/bucket/`
+	mux.HandleFunc("/{bucket}", s.authorizeXML(s.xmlBucketRoute))
+	mux.HandleFunc("/{bucket}/{object...}", s.authorizeXML(s.xmlObjectRoute(s.proxyXMLRequest)))
+	mux.HandleFunc("/", s.authorizeXML(s.proxyXMLRequest))
+
+	return hideSoftDeleteBucket(xmlPathBuckets, mux)
+}
+
+// Serves an object request natively unless it addresses a sub-resource,
+// which only MinIO knows how to handle. `/bucket/` addresses the bucket.
+func (s *StorageService) xmlObjectRoute(handler http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		if r.PathValue("object") == "" {
+			s.xmlBucketRoute(w, r)
+			return
+		}
+
+		if !isNativeXMLObjectRequest(r) {
+			s.proxyXMLRequest(w, r)
+			return
+		}
+
+		handler(w, r)
+	}
+}
+
+// Serves the bucket sub-resources that would bypass glocal's own state natively,
+// and forwards the rest to MinIO
+func (s *StorageService) xmlBucketRoute(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+
+	switch {
+	case r.Method == http.MethodPost && query.Has("delete"):
+		s.handleXMLDeleteObjects(w, r)
+	case r.Method == http.MethodPut && query.Has("versioning"):
+		s.handleXMLSetVersioning(w, r)
+	default:
+		s.proxyXMLRequest(w, r)
+	}
+}
+
+// Reports whether a request addresses a sub-resource such as `?versioning` or `?lifecycle`
+func hasXMLSubresource(r *http.Request) bool {
+	for key := range r.URL.Query() {
+		if !isAuthQueryParam(key) {
+			return true
+		}
+	}
+
+	return false
+}
+
+func isNativeXMLObjectRequest(r *http.Request) bool {
+
+	// Sub-resources such as `?uploads`, `?uploadId=` or `?tagging`
+	for key := range r.URL.Query() {
+		if key != "generation" && !isAuthQueryParam(key) {
+			return false
+		}
+	}
+
+	return true
+}
+
+// Custom metadata is sent as `x-goog-meta-*` headers on the XML API,
+// or as `x-amz-meta-*` by S3 clients
+func xmlCustomMetadata(header http.Header) map[string]string {
+	var metadata map[string]string
+
+	for key, values := range header {
+		name, ok := strings.CutPrefix(http.CanonicalHeaderKey(key), "X-Goog-Meta-")
+		if !ok {
+			name, ok = strings.CutPrefix(http.CanonicalHeaderKey(key), "X-Amz-Meta-")
+		}
+		if !ok || name == "" {
+			continue
+		}
+
+		if metadata == nil {
+			metadata = make(map[string]string)
+		}
+		metadata[strings.ToLower(name)] = strings.Join(values, ",")
+	}
+
+	return metadata
+}
+
+// Reads the generation preconditions of the XML API, which are sent as headers
+func parseXMLPreconditions(header http.Header) (preconditions, error) {
+	return parsePreconditions(url.Values{
+		"ifGenerationMatch":     {header.Get("X-Goog-If-Generation-Match")},
+		"ifMetagenerationMatch": {header.Get("X-Goog-If-Metageneration-Match")},
+	})
+}
+
+func (s *StorageService) handleXMLPutObject(w http.ResponseWriter, r *http.Request) {
+	if xmlCopySource(r.Header) != "" {
+		s.handleXMLCopyObject(w, r)
+		return
+	}
+
+	bucket := r.PathValue("bucket")
+	name := r.PathValue("object")
+
+	conds, err := parseXMLPreconditions(r.Header)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	acl, err := s.parsePredefinedObjectACL(bucket, "x-goog-acl", xmlCannedACL(r.Header.Get("X-Goog-Acl")))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	key, err := parseCustomerKey(r.Header, encryptionHeaderPrefix)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	data, err := spoolUpload(r.Body)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	defer data.Close()
+
+	unlock, err := s.guardOverwrite(r.Context(), bucket, name, conds)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	defer unlock()
+
+	info, err := s.writeObject(r.Context(), bucket, name, data, objectAttrs{
+		ContentType:        r.Header.Get("Content-Type"),
+		ContentEncoding:    r.Header.Get("Content-Encoding"),
+		ContentDisposition: r.Header.Get("Content-Disposition"),
+		ContentLanguage:    r.Header.Get("Content-Language"),
+		CacheControl:       r.Header.Get("Cache-Control"),
+		Metadata:           xmlCustomMetadata(r.Header),
+		KMSKeyName:         r.Header.Get("X-Goog-Encryption-Kms-Key-Name"),
+	}, key)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	s.initObjectACL(bucket, info, acl)
+
+	s.writeXMLObjectHeaders(w.Header(), bucket, info)
+	w.WriteHeader(http.StatusOK)
+}
+
+// S3 clients send the copy source under its `x-amz-` name
+func xmlCopySource(header http.Header) string {
+	if source := header.Get("X-Goog-Copy-Source"); source != "" {
+		return source
+	}
+
+	return header.Get("X-Amz-Copy-Source")
+}
+
+// Returns the bucket named by a copy source, which may or may not be escaped
+func xmlCopySourceBucket(source string) string {
+	bucket, _, _ := strings.Cut(strings.TrimPrefix(source, "/"), "/")
+	if unescaped, err := url.PathUnescape(bucket); err == nil {
+		return unescaped
+	}
+
+	return bucket
+}
+
+type xmlCopyObjectResult struct {
+	XMLName      xml.Name `xml:"CopyObjectResult"`
+	LastModified string   `xml:"LastModified"`
+	ETag         string   `xml:"ETag"`
+}
+
+// Handles a PUT carrying `x-goog-copy-source: /bucket/object`, which copies
+// the source into a new generation instead of uploading data
+func (s *StorageService) handleXMLCopyObject(w http.ResponseWriter, r *http.Request) {
+	source, err := url.PathUnescape(xmlCopySource(r.Header))
+	if err != nil {
+		writeError(w, errBadRequest("Invalid copy source: %v", err))
+		return
+	}
+
+	srcBucket, srcName, ok := strings.Cut(strings.TrimPrefix(source, "/"), "/")
+	if !ok || srcBucket == "" || srcName == "" {
+		writeError(w, errBadRequest("Invalid copy source: %s", source))
+		return
+	}
+
+	conds, err := parseXMLPreconditions(r.Header)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	acl, err := s.parsePredefinedObjectACL(r.PathValue("bucket"), "x-goog-acl", xmlCannedACL(r.Header.Get("X-Goog-Acl")))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	srcKey, dstKey, err := parseCopyKeys(r.Header)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	src, err := s.statGeneration(r.Context(), srcBucket, srcName, r.Header.Get("X-Goog-Copy-Source-Generation"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	// The source metadata is kept unless the request asks to replace it
+	override := &Object{KmsKeyName: r.Header.Get("X-Goog-Encryption-Kms-Key-Name")}
+	if strings.EqualFold(r.Header.Get("X-Goog-Metadata-Directive"), "REPLACE") ||
+		strings.EqualFold(r.Header.Get("X-Amz-Metadata-Directive"), "REPLACE") {
+		override = &Object{
+			ContentType:        r.Header.Get("Content-Type"),
+			ContentEncoding:    r.Header.Get("Content-Encoding"),
+			ContentDisposition: r.Header.Get("Content-Disposition"),
+			ContentLanguage:    r.Header.Get("Content-Language"),
+			CacheControl:       r.Header.Get("Cache-Control"),
+			Metadata:           xmlCustomMetadata(r.Header),
+			KmsKeyName:         override.KmsKeyName,
+		}
+		if override.Metadata == nil {
+			override.Metadata = map[string]string{}
+		}
+	}
+
+	info, err := s.copyObject(r.Context(), srcBucket, src, r.PathValue("bucket"), r.PathValue("object"), override, conds, srcKey, dstKey)
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	s.initObjectACL(r.PathValue("bucket"), info, acl)
+
+	s.writeXMLObjectHeaders(w.Header(), r.PathValue("bucket"), info)
+	writeXML(w, http.StatusOK, xmlCopyObjectResult{
+		LastModified: formatTime(info.LastModified),
+		ETag:         `"` + info.ETag + `"`,
+	})
+}
+
+// Sets the headers the XML API answers writes with
+func (s *StorageService) writeXMLObjectHeaders(header http.Header, bucket string, info minio.ObjectInfo) {
+	attrs := parseObjectAttrs(info)
+	setMediaHeaders(header, info, attrs, s.objectStorageClass(bucket, attrs))
+
+	// Writes carry no content, so drop the headers describing it
+	for _, key := range []string{"Content-Type", "Content-Encoding", "Content-Disposition", "Content-Language", "Cache-Control"} {
+		header.Del(key)
+	}
+}
+
+func writeXML(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/xml; charset=UTF-8")
+	w.WriteHeader(status)
+	w.Write([]byte(xml.Header))
+	_ = xml.NewEncoder(w).Encode(v)
+}
+
+func errMalformedXML() *apiError {
+	err := errBadRequest("The XML you provided was not well-formed or did not validate against our published schema.")
+	err.XMLCode = "MalformedXML"
+	return err
+}
+
+// Most keys S3 accepts in a single multi-object delete
+const maxDeleteObjects = 1000
+
+type xmlDeleteRequest struct {
+	XMLName xml.Name `xml:"Delete"`
+	Quiet   bool     `xml:"Quiet"`
+	Objects []struct {
+		Key       string `xml:"Key"`
+		VersionID string `xml:"VersionId"`
+	} `xml:"Object"`
+}
+
+type xmlDeletedObject struct {
+	Key       string `xml:"Key"`
+	VersionID string `xml:"VersionId,omitempty"`
+}
+
+type xmlDeleteError struct {
+	Key       string `xml:"Key"`
+	VersionID string `xml:"VersionId,omitempty"`
+	Code      string `xml:"Code"`
+	Message   string `xml:"Message"`
+}
+
+type xmlDeleteResult struct {
+	XMLName xml.Name           `xml:"DeleteResult"`
+	Deleted []xmlDeletedObject `xml:"Deleted"`
+	Errors  []xmlDeleteError   `xml:"Error"`
+}
+
+// Handles S3's `POST /bucket?delete`, deleting each object the way a single
+// delete does, so that holds, retention and soft delete apply
+func (s *StorageService) handleXMLDeleteObjects(w http.ResponseWriter, r *http.Request) {
+	bucket := r.PathValue("bucket")
+
+	var req xmlDeleteRequest
+	if err := xml.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, errMalformedXML())
+		return
+	}
+	if len(req.Objects) == 0 || len(req.Objects) > maxDeleteObjects {
+		writeError(w, errBadRequest("A multi-object delete takes between 1 and %d keys.", maxDeleteObjects))
+		return
+	}
+
+	var result xmlDeleteResult
+	for _, object := range req.Objects {
+		// As in S3, deleting something that doesn't exist counts as deleted
+		if err := s.deleteXMLVersion(r.Context(), bucket, object.Key, object.VersionID); err != nil {
+			if apiErr := toAPIError(err); apiErr.Code != http.StatusNotFound {
+				result.Errors = append(result.Errors, xmlDeleteError{
+					Key:       object.Key,
+					VersionID: object.VersionID,
+					Code:      xmlErrorCode(apiErr),
+					Message:   apiErr.Message,
+				})
+				continue
+			}
+		}
+
+		if !req.Quiet {
+			result.Deleted = append(result.Deleted, xmlDeletedObject{Key: object.Key, VersionID: object.VersionID})
+		}
+	}
+
+	writeXML(w, http.StatusOK, result)
+}
+
+// Deletes an object, or one of its versions named by either its generation or,
+// as S3 clients do after listing versions through MinIO, its MinIO version ID
+func (s *StorageService) deleteXMLVersion(ctx context.Context, bucket, name, versionID string) error {
+	generation := versionID
+
+	if _, err := strconv.ParseInt(versionID, 10, 64); versionID != "" && err != nil {
+		info, err := s.client.StatObject(ctx, bucket, name, minio.StatObjectOptions{VersionID: versionID})
+		if err != nil {
+			return err
+		}
+		generation = strconv.FormatInt(parseObjectAttrs(info).Generation, 10)
+	}
+
+	return s.deleteObject(ctx, bucket, name, generation, preconditions{})
+}
+
+type xmlVersioningConfiguration struct {
+	XMLName xml.Name `xml:"VersioningConfiguration"`
+	Status  string   `xml:"Status"`
+}
+
+// Handles `PUT /bucket?versioning`, which switches versioning the way a bucket patch does
+func (s *StorageService) handleXMLSetVersioning(w http.ResponseWriter, r *http.Request) {
+	info, err := s.lookupBucket(r.Context(), r.PathValue("bucket"))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	var config xmlVersioningConfiguration
+	if err := xml.NewDecoder(r.Body).Decode(&config); err != nil {
+		writeError(w, errMalformedXML())
+		return
+	}
+
+	var enabled bool
+	switch config.Status {
+	case "Enabled":
+		enabled = true
+	case "Suspended":
+	default:
+		writeError(w, errBadRequest("Invalid versioning status: %s", config.Status))
+		return
+	}
+
+	_, err = s.updateBucket(r.Context(), info, &enabled, func(attrs *bucketAttrs) error {
+		attrs.Versioning = enabled
+
+		if attrs.Versioning && attrs.RetentionPeriod > 0 {
+			return errVersionedRetention()
+		}
+
+		return nil
+	})
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	w.WriteHeader(http.StatusOK)
+}
@@ -0,0 +1,291 @@
+package storage
+
+import (
+	"bufio"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"strings"
+
+	"github.com/minio/minio-go/v7/pkg/signer"
+)
+
+// Host of the XML API. Virtual-hosted style requests address `bucket.storage.googleapis.com`.
+const xmlAPIHost = "storage.googleapis.com"
+
+// Region MinIO signs requests for unless configured otherwise
+const minioRegion = "us-east-1"
+
+// Returns the bucket a virtual-hosted style XML API request addresses, or
+// an empty string for any other host
+func virtualHostBucket(host string) string {
+	if hostname, _, err := net.SplitHostPort(host); err == nil {
+		host = hostname
+	}
+
+	bucket, ok := strings.CutSuffix(strings.ToLower(host), "."+xmlAPIHost)
+	if !ok {
+		return ""
+	}
+
+	return bucket
+}
+
+// Moves the bucket of a virtual-hosted style request into the path, so
+// that it is routed like the equivalent path-style request
+func toPathStyle(r *http.Request, bucket string) {
+	r.URL.Path = "/" + bucket + r.URL.Path
+	if r.URL.RawPath != "" {
+		r.URL.RawPath = "/" + bucket + r.URL.RawPath
+	}
+}
+
+// Query parameters that authenticate a request rather than address a
+// resource. Signed URLs are checked before routing, and forwarded requests
+// are signed again with the MinIO credentials.
+func isAuthQueryParam(key string) bool {
+	return strings.HasPrefix(key, "X-Goog-") || strings.HasPrefix(key, "X-Amz-") ||
+		key == "GoogleAccessId" || key == "Signature" || key == "Expires"
+}
+
+// S3 names of the XML API request headers MinIO has an equivalent for.
+// Custom metadata is translated by prefix, every other `x-goog-` header is dropped.
+var xmlRequestHeaders = map[string]string{
+	"X-Goog-Copy-Source":                     "X-Amz-Copy-Source",
+	"X-Goog-Copy-Source-If-Match":            "X-Amz-Copy-Source-If-Match",
+	"X-Goog-Copy-Source-If-None-Match":       "X-Amz-Copy-Source-If-None-Match",
+	"X-Goog-Copy-Source-If-Modified-Since":   "X-Amz-Copy-Source-If-Modified-Since",
+	"X-Goog-Copy-Source-If-Unmodified-Since": "X-Amz-Copy-Source-If-Unmodified-Since",
+	"X-Goog-Metadata-Directive":              "X-Amz-Metadata-Directive",
+}
+
+// Headers of the client's own signature, which MinIO would reject
+var xmlAuthHeaders = []string{
+	"Authorization",
+	"X-Amz-Date",
+	"X-Amz-Content-Sha256",
+	"X-Amz-Security-Token",
+}
+
+func translateXMLRequestHeaders(header http.Header) {
+	for key, values := range header {
+		if !strings.HasPrefix(key, "X-Goog-") {
+			continue
+		}
+
+		header.Del(key)
+
+		if name, ok := strings.CutPrefix(key, "X-Goog-Meta-"); ok {
+			header["X-Amz-Meta-"+name] = values
+		} else if s3Key, ok := xmlRequestHeaders[key]; ok {
+			header[s3Key] = values
+		}
+	}
+
+	for _, key := range xmlAuthHeaders {
+		header.Del(key)
+	}
+}
+
+// Renames the `x-amz-` headers of a MinIO response to their `x-goog-`
+// equivalents and drops the ones the XML API has no counterpart for
+func translateXMLResponseHeaders(header http.Header) {
+	for key, values := range header {
+		if !strings.HasPrefix(key, "X-Amz-") && !strings.HasPrefix(key, "X-Minio-") {
+			continue
+		}
+
+		header.Del(key)
+
+		name, ok := strings.CutPrefix(key, "X-Amz-Meta-")
+		if !ok || strings.HasPrefix(name, metaPrefix) {
+			continue
+		}
+		header["X-Goog-Meta-"+name] = values
+	}
+}
+
+// Forwards an XML API request MinIO serves as is, such as bucket listings or multipart uploads
+func (s *StorageService) proxyXMLRequest(w http.ResponseWriter, r *http.Request) {
+	s.xmlProxy.ServeHTTP(w, r)
+}
+
+// Points a forwarded XML API request at MinIO and signs it again. Requests signed with a key
+// created through the HMAC keys API act as the key's MinIO user, so that MinIO applies its
+// service account's policy. With `enforce_iam` set, anonymous requests are forwarded unsigned
+// and get only what MinIO grants anonymous callers. The rest use the MinIO credentials.
+func (s *StorageService) createXMLProxyRewrite(target *url.URL) func(*httputil.ProxyRequest) {
+	return func(pr *httputil.ProxyRequest) {
+		pr.SetURL(target)
+
+		query := pr.Out.URL.Query()
+		for key := range query {
+			if isAuthQueryParam(key) {
+				query.Del(key)
+				pr.Out.URL.RawQuery = query.Encode()
+			}
+		}
+
+		translateXMLRequestHeaders(pr.Out.Header)
+		pr.Out.Header.Del("Origin")
+
+		// The body is streamed through, so its hash is not known up front
+		pr.Out.Header.Set("X-Amz-Content-Sha256", "UNSIGNED-PAYLOAD")
+
+		accessKey, secretKey := s.config.AccessKey, s.config.SecretKey
+		if key, ok := s.hmacKeys.Get(requestAccessID(pr.In)); ok && key.State == hmacKeyActive {
+			accessKey, secretKey = key.AccessID, key.Secret
+		} else if s.config.EnforceIAM && s.callerIdentity(pr.In) == "" {
+			return
+		}
+
+		pr.Out = signer.SignV4(*pr.Out, accessKey, secretKey, "", minioRegion)
+	}
+}
+
+// Translates the headers of MinIO responses and replaces S3 error bodies with XML API errors
+func (s *StorageService) rewriteXMLProxyResponse(resp *http.Response) error {
+	if resp.StatusCode >= http.StatusBadRequest {
+		apiErr, err := readProxyError(resp)
+		if err != nil {
+			return err
+		}
+
+		payload, err := marshalXMLError(apiErr)
+		if err != nil {
+			return fmt.Errorf("failed to encode error response: %w", err)
+		}
+
+		replaceErrorBody(resp, apiErr.Code, "application/xml; charset=UTF-8", payload)
+	}
+
+	translateXMLResponseHeaders(resp.Header)
+
+	if resp.StatusCode < http.StatusMultipleChoices && isXMLBucketChange(resp.Request) {
+		s.syncServiceAccountPolicies(resp.Request.Context())
+	}
+
+	return nil
+}
+
+// Reports whether a forwarded request creates or deletes a bucket
+func isXMLBucketChange(r *http.Request) bool {
+	if r.Method != http.MethodPut && r.Method != http.MethodDelete {
+		return false
+	}
+
+	bucket := strings.Trim(r.URL.Path, "/")
+	return bucket != "" && !strings.Contains(bucket, "/") && r.URL.RawQuery == ""
+}
+
+// Reads the payload of an `aws-chunked` body, which S3 clients send with
+// `STREAMING-*` payload signatures. Each chunk is framed as
+// `<hex size>;chunk-signature=...\r\n<data>\r\n`, and the zero-length last
+// chunk may be followed by trailing checksum headers.
+type awsChunkedReader struct {
+	r         *bufio.Reader
+	remaining int64
+	done      bool
+}
+
+func (c *awsChunkedReader) Read(p []byte) (int, error) {
+	for c.remaining == 0 {
+		if c.done {
+			return 0, io.EOF
+		}
+		if err := c.nextChunk(); err != nil {
+			return 0, err
+		}
+	}
+
+	if int64(len(p)) > c.remaining {
+		p = p[:c.remaining]
+	}
+
+	n, err := c.r.Read(p)
+	c.remaining -= int64(n)
+	if err == io.EOF {
+		err = io.ErrUnexpectedEOF
+	}
+
+	if c.remaining == 0 && err == nil {
+		err = c.readCRLF()
+	}
+
+	return n, err
+}
+
+func (c *awsChunkedReader) nextChunk() error {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return fmt.Errorf("failed to read aws-chunked header: %w", err)
+	}
+
+	sizeHex, _, _ := strings.Cut(strings.TrimRight(line, "\r\n"), ";")
+	size, err := strconv.ParseInt(sizeHex, 16, 64)
+	if err != nil || size < 0 {
+		return fmt.Errorf("invalid aws-chunked chunk size %q", sizeHex)
+	}
+
+	if size > 0 {
+		c.remaining = size
+		return nil
+	}
+
+	// Skip the trailers up to the blank line ending the body
+	c.done = true
+	for {
+		line, err := c.r.ReadString('\n')
+		if err != nil || strings.TrimRight(line, "\r\n") == "" {
+			return nil
+		}
+	}
+}
+
+func (c *awsChunkedReader) readCRLF() error {
+	line, err := c.r.ReadString('\n')
+	if err != nil || strings.TrimRight(line, "\r\n") != "" {
+		return errors.New("malformed aws-chunked body")
+	}
+
+	return nil
+}
+
+// Replaces an `aws-chunked` request body with the payload it carries, so
+// that handlers and MinIO see the plain object data
+func decodeAWSChunked(r *http.Request) {
+	if !strings.HasPrefix(r.Header.Get("X-Amz-Content-Sha256"), "STREAMING-") {
+		return
+	}
+
+	r.Body = struct {
+		io.Reader
+		io.Closer
+	}{&awsChunkedReader{r: bufio.NewReader(r.Body)}, r.Body}
+
+	r.ContentLength = -1
+	if size, err := strconv.ParseInt(r.Header.Get("X-Amz-Decoded-Content-Length"), 10, 64); err == nil {
+		r.ContentLength = size
+		r.Header.Set("Content-Length", strconv.FormatInt(size, 10))
+	}
+
+	var encodings []string
+	for _, encoding := range strings.Split(r.Header.Get("Content-Encoding"), ",") {
+		if encoding = strings.TrimSpace(encoding); encoding != "" && encoding != "aws-chunked" {
+			encodings = append(encodings, encoding)
+		}
+	}
+	r.Header.Del("Content-Encoding")
+	if len(encodings) > 0 {
+		r.Header.Set("Content-Encoding", strings.Join(encodings, ","))
+	}
+
+	r.Header.Del("X-Amz-Decoded-Content-Length")
+	r.Header.Del("X-Amz-Trailer")
+	r.Header.Del("X-Amz-Content-Sha256")
+}